@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Strategy выбирает одну ноду из списка активных под конкретный запрос.
+// Реализации не обязаны быть потокобезопасными сами по себе — LoadBalancer
+// вызывает Pick под своим мьютексом.
+type Strategy interface {
+	Name() string
+	Pick(req *http.Request, nodes []*Node) *Node
+}
+
+// roundRobinStrategy перебирает ноды по кругу в порядке, в котором они
+// приходят от ClusterManager.
+type roundRobinStrategy struct {
+	index int
+}
+
+func (s *roundRobinStrategy) Name() string { return "round_robin" }
+
+func (s *roundRobinStrategy) Pick(req *http.Request, nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	node := nodes[s.index%len(nodes)]
+	s.index++
+	return node
+}
+
+// weightedRoundRobinStrategy распределяет запросы пропорционально
+// Node.Capacity: нода с capacity=200 получает вдвое больше запросов, чем
+// нода с capacity=100.
+type weightedRoundRobinStrategy struct {
+	counter int
+}
+
+func (s *weightedRoundRobinStrategy) Name() string { return "weighted_round_robin" }
+
+func (s *weightedRoundRobinStrategy) Pick(req *http.Request, nodes []*Node) *Node {
+	totalWeight := 0
+	for _, node := range nodes {
+		weight := node.Capacity
+		if weight <= 0 {
+			weight = 1
+		}
+		totalWeight += weight
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+
+	target := s.counter % totalWeight
+	s.counter++
+
+	for _, node := range nodes {
+		weight := node.Capacity
+		if weight <= 0 {
+			weight = 1
+		}
+		if target < weight {
+			return node
+		}
+		target -= weight
+	}
+	return nodes[len(nodes)-1]
+}
+
+// leastConnectionsStrategy отправляет запрос ноде с наименьшим числом
+// запросов в процессе (Node.InFlight, обновляется атомарно в proxyHandler).
+type leastConnectionsStrategy struct{}
+
+func (s *leastConnectionsStrategy) Name() string { return "least_connections" }
+
+func (s *leastConnectionsStrategy) Pick(req *http.Request, nodes []*Node) *Node {
+	var best *Node
+	var bestInFlight int64
+	for _, node := range nodes {
+		inFlight := atomic.LoadInt64(&node.InFlight)
+		if best == nil || inFlight < bestInFlight {
+			best = node
+			bestInFlight = inFlight
+		}
+	}
+	return best
+}
+
+// powerOfTwoChoicesStrategy — классический P2C: смотрим на две случайные
+// ноды (здесь, за неимением crypto-рандома в горячем пути, берём соседние
+// по круговому счётчику) и выбираем ту, у которой меньше Load.
+type powerOfTwoChoicesStrategy struct {
+	index int
+}
+
+func (s *powerOfTwoChoicesStrategy) Name() string { return "power_of_two_choices" }
+
+func (s *powerOfTwoChoicesStrategy) Pick(req *http.Request, nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+
+	i := s.index % len(nodes)
+	j := (s.index + 1) % len(nodes)
+	s.index += 2
+
+	a, b := nodes[i], nodes[j]
+	if atomic.LoadInt64(&a.Load) <= atomic.LoadInt64(&b.Load) {
+		return a
+	}
+	return b
+}
+
+// ewmaStrategy выбирает ноду с наименьшим экспоненциально сглаженным
+// временем ответа (Node.ResponseEWMA, см. LoadBalancer.RecordResponseTime).
+// Ноды без истории (EWMA == 0) считаются наилучшими, чтобы новые ноды
+// получили свою порцию трафика; если таких нод несколько, index крутит их
+// по кругу, иначе строгое сравнение всегда отдавало бы трафик только
+// первой из них по порядку итерации, морозя остальные до первого сэмпла.
+type ewmaStrategy struct {
+	index int
+}
+
+func (s *ewmaStrategy) Name() string { return "ewma" }
+
+func (s *ewmaStrategy) Pick(req *http.Request, nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	best := nodes[0].ResponseEWMA
+	for _, node := range nodes[1:] {
+		if node.ResponseEWMA < best {
+			best = node.ResponseEWMA
+		}
+	}
+
+	tied := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.ResponseEWMA == best {
+			tied = append(tied, node)
+		}
+	}
+
+	node := tied[s.index%len(tied)]
+	s.index++
+	return node
+}
+
+// ringEntry — одна точка кольца consistentHashStrategy: хэш виртуального
+// узла и нода, за которой он закреплён.
+type ringEntry struct {
+	hash uint32
+	node *Node
+}
+
+// consistentHashStrategy закрепляет клиента за одной нодой по хэшу ключа
+// (заголовок, cookie или IP клиента), используя кольцо с виртуальными
+// узлами для равномерного распределения при изменении состава нод.
+type consistentHashStrategy struct {
+	HeaderName string
+	CookieName string
+	Replicas   int
+
+	// cachedFingerprint/cachedRing кэшируют построенное и отсортированное
+	// кольцо между вызовами Pick: без этого оно пересобиралось бы и
+	// сортировалось на каждый проксируемый запрос (O(nodes*Replicas*log)
+	// аллокаций и сравнений). Кольцо пересобирается только когда состав
+	// нод меняется. Мьютекс не нужен — Pick всегда вызывается под
+	// lb.mutex (см. Strategy).
+	cachedFingerprint uint64
+	cachedRing        []ringEntry
+}
+
+func newConsistentHashStrategy(header, cookie string) *consistentHashStrategy {
+	return &consistentHashStrategy{HeaderName: header, CookieName: cookie, Replicas: 100}
+}
+
+func (s *consistentHashStrategy) Name() string { return "consistent_hash" }
+
+func (s *consistentHashStrategy) stickyKey(req *http.Request) string {
+	if s.HeaderName != "" {
+		if v := req.Header.Get(s.HeaderName); v != "" {
+			return v
+		}
+	}
+	if s.CookieName != "" {
+		if c, err := req.Cookie(s.CookieName); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+func (s *consistentHashStrategy) Pick(req *http.Request, nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	ring := s.ringFor(nodes)
+
+	keyHash := crc32.ChecksumIEEE([]byte(s.stickyKey(req)))
+	idx := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= keyHash })
+	if idx == len(ring) {
+		idx = 0
+	}
+	return ring[idx].node
+}
+
+// ringFor возвращает кольцо виртуальных узлов для текущего состава нод,
+// пересобирая и сортируя его только если состав изменился с прошлого
+// вызова (по fingerprint'у, см. nodesFingerprint).
+func (s *consistentHashStrategy) ringFor(nodes []*Node) []ringEntry {
+	fp := nodesFingerprint(nodes)
+	if s.cachedRing != nil && fp == s.cachedFingerprint {
+		return s.cachedRing
+	}
+
+	ring := make([]ringEntry, 0, len(nodes)*s.Replicas)
+	for _, node := range nodes {
+		for v := 0; v < s.Replicas; v++ {
+			vnode := fmt.Sprintf("%s#%d", node.ID, v)
+			ring = append(ring, ringEntry{hash: crc32.ChecksumIEEE([]byte(vnode)), node: node})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	s.cachedFingerprint = fp
+	s.cachedRing = ring
+	return ring
+}
+
+// nodesFingerprint — дешёвый, не зависящий от порядка "отпечаток" состава
+// нод, по которому ringFor определяет, что кольцо нужно пересобрать.
+// Простая сумма не заметила бы перестановку ID между двумя нодами, но
+// узнаёт добавление/удаление/переименование ноды, что и требуется для
+// инвалидации кэша при изменении членства в кластере.
+func nodesFingerprint(nodes []*Node) uint64 {
+	var fp uint64
+	for _, node := range nodes {
+		fp += uint64(crc32.ChecksumIEEE([]byte(node.ID))) + 1
+	}
+	return fp<<32 | uint64(len(nodes))
+}
+
+// LoadBalancer выбирает ноду для очередного запроса через подключаемую
+// Strategy; стратегию можно переключить на лету через
+// /api/balancer/strategy.
+type LoadBalancer struct {
+	clusterManager *ClusterManager
+
+	mutex    sync.Mutex
+	strategy Strategy
+
+	// proxyManager, если задан, используется для пропуска нод с открытым
+	// circuit breaker'ом при выборе следующей ноды.
+	proxyManager *ReverseProxyManager
+
+	// currentIndex сохраняется отдельно для обратной совместимости со
+	// status-хендлером, который исторически отдавал текущий индекс
+	// round-robin.
+	currentIndex int
+}
+
+func NewLoadBalancer(cm *ClusterManager) *LoadBalancer {
+	return &LoadBalancer{
+		clusterManager: cm,
+		strategy:       &roundRobinStrategy{},
+	}
+}
+
+// SetProxyManager подключает менеджер прокси, чтобы балансировщик мог
+// учитывать состояние предохранителя при выборе ноды.
+func (lb *LoadBalancer) SetProxyManager(rpm *ReverseProxyManager) {
+	lb.proxyManager = rpm
+}
+
+func (lb *LoadBalancer) StrategyName() string {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.strategy.Name()
+}
+
+// CurrentIndex возвращает число успешных выборов ноды с момента создания
+// балансировщика, используемое только в /api/balancer/status. currentIndex
+// пишется в pick под lb.mutex, поэтому читать его тоже нужно под ним —
+// прямое обращение к полю было бы гонкой данных.
+func (lb *LoadBalancer) CurrentIndex() int {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.currentIndex
+}
+
+// SetStrategy переключает активную стратегию балансировки по имени.
+func (lb *LoadBalancer) SetStrategy(name string) error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	switch name {
+	case "round_robin":
+		lb.strategy = &roundRobinStrategy{}
+	case "weighted_round_robin":
+		lb.strategy = &weightedRoundRobinStrategy{}
+	case "least_connections":
+		lb.strategy = &leastConnectionsStrategy{}
+	case "power_of_two_choices":
+		lb.strategy = &powerOfTwoChoicesStrategy{}
+	case "ewma":
+		lb.strategy = &ewmaStrategy{}
+	case "consistent_hash":
+		lb.strategy = newConsistentHashStrategy("X-Session-ID", "session_id")
+	default:
+		return fmt.Errorf("неизвестная стратегия балансировки: %s", name)
+	}
+	return nil
+}
+
+func (lb *LoadBalancer) GetNextNode() *Node {
+	return lb.Pick(nil)
+}
+
+// Pick выбирает следующую ноду активной стратегией, пропуская ноды с
+// открытым circuit breaker'ом.
+func (lb *LoadBalancer) Pick(req *http.Request) *Node {
+	return lb.pick(req, nil)
+}
+
+// PickExcluding выбирает следующую ноду, дополнительно пропуская те, что
+// перечислены в exclude (используется прокси для повтора на другой ноде).
+func (lb *LoadBalancer) PickExcluding(exclude map[string]bool) *Node {
+	return lb.pick(nil, exclude)
+}
+
+func (lb *LoadBalancer) pick(req *http.Request, exclude map[string]bool) *Node {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	candidates := make([]*Node, 0)
+	for _, node := range lb.clusterManager.GetActiveNodes() {
+		if exclude[node.ID] {
+			continue
+		}
+		if lb.proxyManager != nil && lb.proxyManager.BreakerOpen(node) {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+
+	if req == nil {
+		req = &http.Request{}
+	}
+
+	node := lb.strategy.Pick(req, candidates)
+	if node != nil {
+		lb.currentIndex++
+	}
+	return node
+}
+
+// RecordResponseTime обновляет экспоненциально сглаженное время ответа
+// ноды, используемое стратегией ewma. Защищено мьютексом LoadBalancer,
+// так что вызывающая сторона может звать его конкурентно.
+func (lb *LoadBalancer) RecordResponseTime(node *Node, d time.Duration) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	const alpha = 0.2
+	ms := float64(d.Milliseconds())
+
+	if node.ResponseEWMA == 0 {
+		node.ResponseEWMA = ms
+	} else {
+		node.ResponseEWMA = alpha*ms + (1-alpha)*node.ResponseEWMA
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func testNodes(n int) []*Node {
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		nodes[i] = &Node{ID: fmt.Sprintf("node-%d", i)}
+	}
+	return nodes
+}
+
+func TestRoundRobinStrategyCyclesEvenly(t *testing.T) {
+	nodes := testNodes(3)
+	s := &roundRobinStrategy{}
+
+	counts := map[string]int{}
+	for i := 0; i < 9; i++ {
+		node := s.Pick(&http.Request{}, nodes)
+		if node == nil {
+			t.Fatalf("Pick returned nil on iteration %d", i)
+		}
+		counts[node.ID]++
+	}
+
+	for _, node := range nodes {
+		if counts[node.ID] != 3 {
+			t.Errorf("node %s got %d picks, want 3 (even distribution over 9 requests)", node.ID, counts[node.ID])
+		}
+	}
+}
+
+func TestRoundRobinStrategyEmptyNodes(t *testing.T) {
+	s := &roundRobinStrategy{}
+	if node := s.Pick(&http.Request{}, nil); node != nil {
+		t.Errorf("Pick on empty node list = %v, want nil", node)
+	}
+}
+
+func TestWeightedRoundRobinRespectsCapacityRatio(t *testing.T) {
+	nodes := []*Node{
+		{ID: "a", Capacity: 100},
+		{ID: "b", Capacity: 200},
+	}
+	s := &weightedRoundRobinStrategy{}
+
+	counts := map[string]int{}
+	const total = 300
+	for i := 0; i < total; i++ {
+		node := s.Pick(&http.Request{}, nodes)
+		counts[node.ID]++
+	}
+
+	if counts["a"] != 100 || counts["b"] != 200 {
+		t.Errorf("got counts %v, want a=100 b=200 (1:2 capacity ratio over %d requests)", counts, total)
+	}
+}
+
+func TestLeastConnectionsPicksLowestInFlight(t *testing.T) {
+	nodes := []*Node{
+		{ID: "a", InFlight: 5},
+		{ID: "b", InFlight: 1},
+		{ID: "c", InFlight: 3},
+	}
+	s := &leastConnectionsStrategy{}
+
+	node := s.Pick(&http.Request{}, nodes)
+	if node == nil || node.ID != "b" {
+		t.Errorf("Pick = %v, want node b (lowest InFlight)", node)
+	}
+}
+
+func TestPowerOfTwoChoicesPicksLowerLoad(t *testing.T) {
+	nodes := []*Node{
+		{ID: "a", Load: 10},
+		{ID: "b", Load: 2},
+	}
+	s := &powerOfTwoChoicesStrategy{}
+
+	node := s.Pick(&http.Request{}, nodes)
+	if node == nil || node.ID != "b" {
+		t.Errorf("Pick = %v, want node b (lower Load of the sampled pair)", node)
+	}
+}
+
+func TestEWMAStrategyPicksLowestResponseTime(t *testing.T) {
+	nodes := []*Node{
+		{ID: "a", ResponseEWMA: 50},
+		{ID: "b", ResponseEWMA: 10},
+		{ID: "c", ResponseEWMA: 30},
+	}
+	s := &ewmaStrategy{}
+
+	node := s.Pick(&http.Request{}, nodes)
+	if node == nil || node.ID != "b" {
+		t.Errorf("Pick = %v, want node b (lowest ResponseEWMA)", node)
+	}
+}
+
+func TestEWMAStrategyPrefersNodesWithoutHistory(t *testing.T) {
+	nodes := []*Node{
+		{ID: "a", ResponseEWMA: 50},
+		{ID: "b", ResponseEWMA: 0},
+	}
+	s := &ewmaStrategy{}
+
+	node := s.Pick(&http.Request{}, nodes)
+	if node == nil || node.ID != "b" {
+		t.Errorf("Pick = %v, want node b (no history yet, EWMA == 0)", node)
+	}
+}
+
+func TestEWMAStrategyRoundRobinsAmongTiedNodes(t *testing.T) {
+	nodes := []*Node{
+		{ID: "a", ResponseEWMA: 0},
+		{ID: "b", ResponseEWMA: 0},
+		{ID: "c", ResponseEWMA: 30},
+	}
+	s := &ewmaStrategy{}
+
+	picked := map[string]int{}
+	for i := 0; i < 4; i++ {
+		node := s.Pick(&http.Request{}, nodes)
+		if node.ID != "a" && node.ID != "b" {
+			t.Fatalf("Pick #%d = %s, want one of the tied zero-EWMA nodes", i, node.ID)
+		}
+		picked[node.ID]++
+	}
+
+	if picked["a"] == 0 || picked["b"] == 0 {
+		t.Errorf("Pick distribution = %v, want both tied nodes to get traffic instead of starving one of them", picked)
+	}
+}
+
+func TestConsistentHashStrategyIsSticky(t *testing.T) {
+	nodes := testNodes(5)
+	s := newConsistentHashStrategy("X-Session-ID", "session_id")
+
+	req := &http.Request{Header: http.Header{"X-Session-Id": []string{"user-42"}}}
+
+	first := s.Pick(req, nodes)
+	for i := 0; i < 20; i++ {
+		node := s.Pick(req, nodes)
+		if node.ID != first.ID {
+			t.Fatalf("Pick #%d = %s, want sticky %s for the same key", i, node.ID, first.ID)
+		}
+	}
+}
+
+func TestConsistentHashStrategyStableUnderNodeChurn(t *testing.T) {
+	nodes := testNodes(10)
+	s := newConsistentHashStrategy("X-Session-ID", "session_id")
+
+	req := &http.Request{Header: http.Header{"X-Session-Id": []string{"user-7"}}}
+	before := s.Pick(req, nodes)
+
+	// Убираем одну ноду, отличную от выбранной, чтобы проверить, что
+	// остальные ключи не массово переезжают на другую ноду.
+	reduced := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.ID != "node-0" {
+			reduced = append(reduced, node)
+		}
+	}
+
+	if before.ID == "node-0" {
+		t.Skip("выбранная нода совпала с удаляемой, переезд ожидаем — пропускаем")
+	}
+
+	after := s.Pick(req, reduced)
+	if after.ID != before.ID {
+		t.Errorf("после удаления не связанной ноды Pick = %s, want %s (consistent hashing должен перемещать лишь малую долю ключей)", after.ID, before.ID)
+	}
+}
+
+func TestConsistentHashStrategyCachesRingUntilMembershipChanges(t *testing.T) {
+	nodes := testNodes(5)
+	s := newConsistentHashStrategy("X-Session-ID", "session_id")
+
+	ring := s.ringFor(nodes)
+	if got := s.ringFor(nodes); &got[0] != &ring[0] {
+		t.Errorf("ringFor rebuilt the ring for an unchanged node set, want the cached slice reused")
+	}
+
+	reduced := nodes[1:]
+	if got := s.ringFor(reduced); &got[0] == &ring[0] {
+		t.Errorf("ringFor reused the cached ring after node membership changed, want a rebuild")
+	}
+}
+
+func TestConsistentHashStrategyEmptyNodes(t *testing.T) {
+	s := newConsistentHashStrategy("X-Session-ID", "session_id")
+	if node := s.Pick(&http.Request{}, nil); node != nil {
+		t.Errorf("Pick on empty node list = %v, want nil", node)
+	}
+}
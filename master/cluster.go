@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Статусы ноды на протяжении её жизненного цикла в кластере. "active" —
+// переходное состояние сразу после регистрации, до первой проверки
+// здоровья; дальше HealthChecker переводит ноду в "healthy"/"unhealthy".
+const (
+	NodeStatusActive    = "active"
+	NodeStatusHealthy   = "healthy"
+	NodeStatusUnhealthy = "unhealthy"
+)
+
+type Node struct {
+	ID       string    `json:"id"`
+	Address  string    `json:"address"`
+	Port     int       `json:"port"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+	Capacity int       `json:"capacity"`
+
+	// Load, InFlight и ResponseEWMA — рантайм-счётчики, читаемые
+	// стратегиями балансировки под lb.mutex, в то время как Load пишется
+	// из apply под не связанным с ним cm.mutex — поэтому, в отличие от
+	// ResponseEWMA (которую и пишут, и читают только под lb.mutex), Load
+	// нужно читать и писать атомарно, как уже делает InFlight. В JSON не
+	// попадают — см. MarshalJSON, который отдаёт атомарный снимок Load.
+	Load         int64   `json:"-"`
+	InFlight     int64   `json:"-"`
+	ResponseEWMA float64 `json:"-"`
+}
+
+// nodeJSON — форма Node для /api/cluster/nodes и подобных ручек: то же
+// множество полей, что было в Node до того, как Load стал
+// атомарно-читаемым счётчиком, плюс Load в виде обычного числа.
+type nodeJSON struct {
+	ID       string    `json:"id"`
+	Address  string    `json:"address"`
+	Port     int       `json:"port"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+	Load     int64     `json:"load"`
+	Capacity int       `json:"capacity"`
+}
+
+// MarshalJSON отдаёт Load атомарным снимком вместо прямого чтения поля —
+// оно пишется из apply под cm.mutex, а не под тем мьютексом, которым
+// защищено большинство остальных полей Node на момент сериализации.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nodeJSON{
+		ID:       n.ID,
+		Address:  n.Address,
+		Port:     n.Port,
+		Status:   n.Status,
+		LastSeen: n.LastSeen,
+		Load:     atomic.LoadInt64(&n.Load),
+		Capacity: n.Capacity,
+	})
+}
+
+// NodeEventType перечисляет события жизненного цикла ноды, на которые
+// можно подписаться через ClusterManager.Subscribe.
+type NodeEventType string
+
+const (
+	NodeEventRegistered NodeEventType = "registered"
+	NodeEventHealthy    NodeEventType = "healthy"
+	NodeEventUnhealthy  NodeEventType = "unhealthy"
+	NodeEventRemoved    NodeEventType = "removed"
+)
+
+type NodeEvent struct {
+	Type      NodeEventType `json:"type"`
+	Node      *Node         `json:"node"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+type ClusterManager struct {
+	nodes map[string]*Node
+	mutex sync.RWMutex
+
+	subMutex    sync.Mutex
+	subscribers map[chan NodeEvent]struct{}
+
+	// store решает, применять ли мутации сразу (MemoryStore) или сначала
+	// провести их через Raft-консенсус (RaftNode). По умолчанию —
+	// MemoryStore, то есть поведение без реплицированного кластера не
+	// меняется.
+	store Store
+}
+
+func NewClusterManager() *ClusterManager {
+	cm := &ClusterManager{
+		nodes:       make(map[string]*Node),
+		subscribers: make(map[chan NodeEvent]struct{}),
+	}
+	cm.store = NewMemoryStore(cm)
+	return cm
+}
+
+// SetStore подключает Store, обычно RaftNode, через который дальше идут
+// все мутации реестра нод. Должен вызываться до первой мутации.
+func (cm *ClusterManager) SetStore(store Store) {
+	cm.store = store
+}
+
+func (cm *ClusterManager) RegisterNode(id, address string, port int) error {
+	return cm.store.Propose(Command{Op: OpRegister, ID: id, Address: address, Port: port})
+}
+
+// DeregisterNode убирает ноду из реестра (по явному запросу оператора или
+// потому что TTL её heartbeat'а истёк) и публикует событие "removed".
+func (cm *ClusterManager) DeregisterNode(id string) error {
+	return cm.store.Propose(Command{Op: OpDeregister, ID: id})
+}
+
+// GetActiveNodes возвращает ноды, пригодные для обслуживания трафика —
+// то есть ещё не помеченные как unhealthy HealthChecker'ом.
+func (cm *ClusterManager) GetActiveNodes() []*Node {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	var activeNodes []*Node
+	for _, node := range cm.nodes {
+		if node.Status != NodeStatusUnhealthy {
+			activeNodes = append(activeNodes, node)
+		}
+	}
+	return activeNodes
+}
+
+// AllNodes возвращает все известные ноды независимо от статуса здоровья —
+// используется HealthChecker'ом, которому нужно пробовать и unhealthy ноды,
+// чтобы заметить их восстановление.
+func (cm *ClusterManager) AllNodes() []*Node {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	nodes := make([]*Node, 0, len(cm.nodes))
+	for _, node := range cm.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func (cm *ClusterManager) UpdateNodeLoad(id string, load int) error {
+	return cm.store.Propose(Command{Op: OpUpdateLoad, ID: id, Load: load})
+}
+
+// Heartbeat продлевает LastSeen ноды, не трогая её нагрузку.
+func (cm *ClusterManager) Heartbeat(id string) error {
+	return cm.store.Propose(Command{Op: OpHeartbeat, ID: id})
+}
+
+// setNodeStatus переводит ноду в новый статус здоровья и публикует
+// соответствующее событие, если статус действительно изменился.
+func (cm *ClusterManager) setNodeStatus(id string, status string, eventType NodeEventType) {
+	cm.store.Propose(Command{Op: OpSetStatus, ID: id, Status: status, EventType: eventType})
+}
+
+// apply выполняет одну уже согласованную мутацию реестра нод. На узле без
+// Raft её вызывает MemoryStore сразу же; в реплицированном кластере —
+// fsm.Apply на каждой реплике после того, как команда прошла через журнал
+// консенсуса, так что состояние остаётся одинаковым везде.
+func (cm *ClusterManager) apply(cmd Command) error {
+	switch cmd.Op {
+	case OpRegister:
+		cm.mutex.Lock()
+		node := &Node{
+			ID:       cmd.ID,
+			Address:  cmd.Address,
+			Port:     cmd.Port,
+			Status:   NodeStatusActive,
+			LastSeen: time.Now(),
+			Load:     0,
+			Capacity: 100,
+		}
+		cm.nodes[cmd.ID] = node
+		clusterSize := len(cm.nodes)
+		cm.mutex.Unlock()
+
+		if m := currentMetrics(); m != nil {
+			m.SetClusterSize(clusterSize)
+		}
+		logger.Info("нода зарегистрирована", "node_id", cmd.ID, "address", cmd.Address, "port", cmd.Port)
+		cm.publish(NodeEvent{Type: NodeEventRegistered, Node: node, Timestamp: time.Now()})
+		return nil
+
+	case OpDeregister:
+		cm.mutex.Lock()
+		node, exists := cm.nodes[cmd.ID]
+		if !exists {
+			cm.mutex.Unlock()
+			return fmt.Errorf("нода %s не найдена", cmd.ID)
+		}
+		delete(cm.nodes, cmd.ID)
+		clusterSize := len(cm.nodes)
+		cm.mutex.Unlock()
+
+		if m := currentMetrics(); m != nil {
+			m.SetClusterSize(clusterSize)
+		}
+		logger.Info("нода удалена из кластера", "node_id", cmd.ID)
+		cm.publish(NodeEvent{Type: NodeEventRemoved, Node: node, Timestamp: time.Now()})
+		return nil
+
+	case OpUpdateLoad:
+		cm.mutex.Lock()
+		defer cm.mutex.Unlock()
+
+		node, exists := cm.nodes[cmd.ID]
+		if !exists {
+			return fmt.Errorf("нода %s не найдена", cmd.ID)
+		}
+		atomic.StoreInt64(&node.Load, int64(cmd.Load))
+		node.LastSeen = time.Now()
+		return nil
+
+	case OpHeartbeat:
+		cm.mutex.Lock()
+		defer cm.mutex.Unlock()
+
+		node, exists := cm.nodes[cmd.ID]
+		if !exists {
+			return fmt.Errorf("нода %s не найдена", cmd.ID)
+		}
+		node.LastSeen = time.Now()
+		return nil
+
+	case OpSetStatus:
+		cm.mutex.Lock()
+		node, exists := cm.nodes[cmd.ID]
+		if !exists || node.Status == cmd.Status {
+			cm.mutex.Unlock()
+			return nil
+		}
+		node.Status = cmd.Status
+		cm.mutex.Unlock()
+
+		if m := currentMetrics(); m != nil && (cmd.Status == NodeStatusUnhealthy || cmd.EventType == NodeEventHealthy) {
+			m.RecordNodeHealthFlap(cmd.ID)
+		}
+		cm.publish(NodeEvent{Type: cmd.EventType, Node: node, Timestamp: time.Now()})
+		return nil
+
+	default:
+		return fmt.Errorf("неизвестная команда: %s", cmd.Op)
+	}
+}
+
+// Subscribe подписывается на события жизненного цикла нод. Возвращает
+// канал событий и функцию отписки, которую вызывающий обязан вызвать,
+// когда подписка больше не нужна (например, когда SSE-клиент отключился).
+func (cm *ClusterManager) Subscribe() (<-chan NodeEvent, func()) {
+	ch := make(chan NodeEvent, 32)
+
+	cm.subMutex.Lock()
+	cm.subscribers[ch] = struct{}{}
+	cm.subMutex.Unlock()
+
+	unsubscribe := func() {
+		cm.subMutex.Lock()
+		delete(cm.subscribers, ch)
+		cm.subMutex.Unlock()
+		close(ch)
+	}
+	return ch, unsubscribe
+}
+
+// publish рассылает событие всем подписчикам. Медленный подписчик не
+// блокирует остальных — если его буфер полон, событие для него теряется.
+func (cm *ClusterManager) publish(event NodeEvent) {
+	cm.subMutex.Lock()
+	defer cm.subMutex.Unlock()
+
+	for ch := range cm.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logger.Warn("подписчик событий кластера отстаёт, событие потеряно", "event_type", event.Type, "node_id", event.Node.ID)
+		}
+	}
+}
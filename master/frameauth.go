@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MRXTOOR/web-srv/pkg/wire"
+)
+
+// FrameAuthenticator подписывает и проверяет wire.AuthToken на кадрах
+// Register/Heartbeat общим секретом, отклоняя кадры с истёкшей меткой
+// времени или с уже виденным nonce (replay).
+type FrameAuthenticator struct {
+	secret []byte
+	window time.Duration
+
+	mutex sync.Mutex
+	seen  map[string]time.Time // nonce -> когда истекает из replay-окна
+}
+
+func NewFrameAuthenticator(secret string, window time.Duration) *FrameAuthenticator {
+	return &FrameAuthenticator{
+		secret: []byte(secret),
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Sign формирует свежий AuthToken для id по той же схеме
+// ("id:nonce:timestamp", HMAC-SHA256), что и Client.sign в pkg/agent,
+// который считает токен самостоятельно и Sign не вызывает.
+func (fa *FrameAuthenticator) Sign(id, nonce string) wire.AuthToken {
+	now := time.Now().Unix()
+	return wire.AuthToken{
+		Nonce:     nonce,
+		Timestamp: now,
+		HMAC:      fa.mac(id, nonce, now),
+	}
+}
+
+// Verify проверяет подпись, окно свежести и уникальность nonce. Успешная
+// проверка помечает nonce как увиденный до конца replay-окна.
+func (fa *FrameAuthenticator) Verify(id string, token wire.AuthToken) error {
+	if token.Nonce == "" || token.HMAC == "" {
+		return fmt.Errorf("frameauth: отсутствует токен аутентификации")
+	}
+
+	age := time.Since(time.Unix(token.Timestamp, 0))
+	if age < 0 {
+		age = -age
+	}
+	if age > fa.window {
+		return fmt.Errorf("frameauth: метка времени вне окна допустимости (%s)", fa.window)
+	}
+
+	expected := fa.mac(id, token.Nonce, token.Timestamp)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(token.HMAC)) != 1 {
+		return fmt.Errorf("frameauth: неверная подпись")
+	}
+
+	fa.mutex.Lock()
+	defer fa.mutex.Unlock()
+
+	fa.evictExpired()
+	if _, replayed := fa.seen[token.Nonce]; replayed {
+		return fmt.Errorf("frameauth: nonce уже использован (replay)")
+	}
+	fa.seen[token.Nonce] = time.Now().Add(fa.window)
+	return nil
+}
+
+func (fa *FrameAuthenticator) mac(id, nonce string, timestamp int64) string {
+	h := hmac.New(sha256.New, fa.secret)
+	fmt.Fprintf(h, "%s:%s:%d", id, nonce, timestamp)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// evictExpired чистит nonce, чьё replay-окно уже прошло. Вызывается под
+// fa.mutex.
+func (fa *FrameAuthenticator) evictExpired() {
+	now := time.Now()
+	for nonce, expiry := range fa.seen {
+		if now.After(expiry) {
+			delete(fa.seen, nonce)
+		}
+	}
+}
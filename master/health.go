@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HealthCheckConfig настраивает активные проверки здоровья нод.
+type HealthCheckConfig struct {
+	Interval           time.Duration // как часто опрашивать /api/health
+	Timeout            time.Duration // таймаут одного запроса проверки
+	UnhealthyThreshold int           // число подряд неудач до перевода в unhealthy
+	HealthyThreshold   int           // число подряд успехов до перевода обратно в healthy
+	HeartbeatTTL       time.Duration // сколько можно не получать heartbeat, прежде чем удалить ноду
+}
+
+// DefaultHealthCheckConfig возвращает разумные значения по умолчанию.
+func DefaultHealthCheckConfig() *HealthCheckConfig {
+	return &HealthCheckConfig{
+		Interval:           5 * time.Second,
+		Timeout:            2 * time.Second,
+		UnhealthyThreshold: 3,
+		HealthyThreshold:   2,
+		HeartbeatTTL:       30 * time.Second,
+	}
+}
+
+// HealthChecker периодически опрашивает /api/health на каждой известной
+// ноде и переводит её между healthy/unhealthy, а также вычищает ноды,
+// чей heartbeat протух.
+type HealthChecker struct {
+	clusterManager *ClusterManager
+	config         *HealthCheckConfig
+	httpClient     *http.Client
+
+	stop chan struct{}
+
+	// consecutive хранит число подряд успехов (положительное) или неудач
+	// (отрицательное) на ноду, чтобы решать о переходе между состояниями.
+	consecutive map[string]int
+}
+
+func NewHealthChecker(cm *ClusterManager, config *HealthCheckConfig) *HealthChecker {
+	if config == nil {
+		config = DefaultHealthCheckConfig()
+	}
+	return &HealthChecker{
+		clusterManager: cm,
+		config:         config,
+		httpClient:     &http.Client{Timeout: config.Timeout},
+		stop:           make(chan struct{}),
+		consecutive:    make(map[string]int),
+	}
+}
+
+// Start запускает фоновый цикл проверок. Останавливается вызовом Stop.
+func (hc *HealthChecker) Start() {
+	go func() {
+		ticker := time.NewTicker(hc.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				hc.runOnce()
+			case <-hc.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (hc *HealthChecker) Stop() {
+	close(hc.stop)
+}
+
+func (hc *HealthChecker) runOnce() {
+	now := time.Now()
+	for _, node := range hc.clusterManager.AllNodes() {
+		if now.Sub(node.LastSeen) > hc.config.HeartbeatTTL {
+			logger.Warn("нода не присылала heartbeat дольше TTL, удаляем", "node_id", node.ID, "ttl", hc.config.HeartbeatTTL)
+			delete(hc.consecutive, node.ID)
+			hc.clusterManager.DeregisterNode(node.ID)
+			continue
+		}
+
+		hc.probe(node)
+	}
+}
+
+func (hc *HealthChecker) probe(node *Node) {
+	url := fmt.Sprintf("http://%s:%d/api/health", node.Address, node.Port)
+
+	resp, err := hc.httpClient.Get(url)
+	healthy := err == nil && resp.StatusCode == http.StatusOK
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	if healthy {
+		if hc.consecutive[node.ID] < 0 {
+			hc.consecutive[node.ID] = 0
+		}
+		hc.consecutive[node.ID]++
+
+		if node.Status != NodeStatusHealthy && hc.consecutive[node.ID] >= hc.config.HealthyThreshold {
+			hc.clusterManager.setNodeStatus(node.ID, NodeStatusHealthy, NodeEventHealthy)
+		}
+		return
+	}
+
+	if hc.consecutive[node.ID] > 0 {
+		hc.consecutive[node.ID] = 0
+	}
+	hc.consecutive[node.ID]--
+
+	if node.Status != NodeStatusUnhealthy && -hc.consecutive[node.ID] >= hc.config.UnhealthyThreshold {
+		hc.clusterManager.setNodeStatus(node.ID, NodeStatusUnhealthy, NodeEventUnhealthy)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// Logger — узкий интерфейс структурированного логирования, за которым
+// может стоять любой бэкенд (zap, zerolog, стандартный slog). По
+// умолчанию используется slog, чтобы не тащить внешнюю зависимость там,
+// где её не требуют.
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger оборачивает стандартный log/slog в Logger.
+func NewSlogLogger() Logger {
+	return &slogLogger{l: slog.New(slog.NewTextHandler(os.Stdout, nil))}
+}
+
+func (s *slogLogger) Debug(msg string, kv ...any) { s.l.Debug(msg, kv...) }
+func (s *slogLogger) Info(msg string, kv ...any)  { s.l.Info(msg, kv...) }
+func (s *slogLogger) Warn(msg string, kv ...any)  { s.l.Warn(msg, kv...) }
+func (s *slogLogger) Error(msg string, kv ...any) { s.l.Error(msg, kv...) }
+
+// logger — глобальный логгер пакета master. SetLogger позволяет
+// подменить бэкенд (например, адаптером над zap) при старте процесса.
+var logger Logger = NewSlogLogger()
+
+// SetLogger подменяет глобальный логгер пакета.
+func SetLogger(l Logger) {
+	logger = l
+}
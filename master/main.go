@@ -3,119 +3,70 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
-	"net"
 	"net/http"
-	"sync"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
-type Node struct {
-	ID       string    `json:"id"`
-	Address  string    `json:"address"`
-	Port     int       `json:"port"`
-	Status   string    `json:"status"`
-	LastSeen time.Time `json:"last_seen"`
-	Load     int       `json:"load"`
-	Capacity int       `json:"capacity"`
-}
+type HTTPServer struct {
+	clusterManager *ClusterManager
+	loadBalancer   *LoadBalancer
+	reverseProxy   *ReverseProxyManager
+	port           int
 
-type ClusterManager struct {
-	nodes map[string]*Node
-	mutex sync.RWMutex
-}
+	// raftNode не nil, когда мастер запущен как часть реплицированного
+	// кластера membership-серверов (см. RaftConfig в main). Используется
+	// для редиректа мутирующих запросов на лидера и для /api/cluster/join
+	// и /api/cluster/leave.
+	raftNode *RaftNode
 
-func NewClusterManager() *ClusterManager {
-	return &ClusterManager{
-		nodes: make(map[string]*Node),
-	}
+	// security — nil, пока main не включит control plane security через
+	// SecurityConfig; в этом случае TLS и bearer-токен на мутирующих
+	// ручках выключены, как и раньше.
+	security *SecurityConfig
 }
 
-func (cm *ClusterManager) RegisterNode(id, address string, port int) error {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	node := &Node{
-		ID:       id,
-		Address:  address,
-		Port:     port,
-		Status:   "active",
-		LastSeen: time.Now(),
-		Load:     0,
-		Capacity: 100,
-	}
-
-	cm.nodes[id] = node
-	log.Printf("✅ Нода %s зарегистрирована: %s:%d", id, address, port)
-	return nil
-}
-
-func (cm *ClusterManager) GetActiveNodes() []*Node {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
+func NewHTTPServer(cm *ClusterManager, lb *LoadBalancer, port int) *HTTPServer {
+	reverseProxy := NewReverseProxyManager(DefaultProxyConfig())
+	lb.SetProxyManager(reverseProxy)
 
-	var activeNodes []*Node
-	for _, node := range cm.nodes {
-		if node.Status == "active" {
-			activeNodes = append(activeNodes, node)
-		}
+	return &HTTPServer{
+		clusterManager: cm,
+		loadBalancer:   lb,
+		reverseProxy:   reverseProxy,
+		port:           port,
 	}
-	return activeNodes
 }
 
-func (cm *ClusterManager) UpdateNodeLoad(id string, load int) error {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	node, exists := cm.nodes[id]
-	if !exists {
-		return fmt.Errorf("нода %s не найдена", id)
-	}
-
-	node.Load = load
-	node.LastSeen = time.Now()
-	return nil
+// SetRaftNode подключает реплицированный membership-кластер к серверу,
+// включая редирект записи на лидера и ручки /api/cluster/join, /leave.
+func (hs *HTTPServer) SetRaftNode(rn *RaftNode) {
+	hs.raftNode = rn
 }
 
-type LoadBalancer struct {
-	clusterManager *ClusterManager
-	currentIndex   int
-	mutex          sync.Mutex
+// SetSecurity включает TLS на HTTP-листенере и admin bearer-токен на
+// мутирующих ручках /api/cluster/*.
+func (hs *HTTPServer) SetSecurity(security *SecurityConfig) {
+	hs.security = security
 }
 
-func NewLoadBalancer(cm *ClusterManager) *LoadBalancer {
-	return &LoadBalancer{
-		clusterManager: cm,
-		currentIndex:   0,
+// redirectToLeader отвечает 307 с Location на HTTP-адрес текущего лидера,
+// если он известен. Возвращает true, если запрос был обработан (то есть
+// редирект отправлен или лидер ещё не выбран).
+func (hs *HTTPServer) redirectToLeader(w http.ResponseWriter, r *http.Request) bool {
+	if hs.raftNode == nil || hs.raftNode.IsLeader() {
+		return false
 	}
-}
 
-func (lb *LoadBalancer) GetNextNode() *Node {
-	lb.mutex.Lock()
-	defer lb.mutex.Unlock()
-
-	nodes := lb.clusterManager.GetActiveNodes()
-	if len(nodes) == 0 {
-		return nil
+	leaderAddr := hs.raftNode.LeaderAddress()
+	if leaderAddr == "" {
+		http.Error(w, "Leader not yet elected", http.StatusServiceUnavailable)
+		return true
 	}
 
-	node := nodes[lb.currentIndex%len(nodes)]
-	lb.currentIndex++
-	return node
-}
-
-type HTTPServer struct {
-	clusterManager *ClusterManager
-	loadBalancer   *LoadBalancer
-	port           int
-}
-
-func NewHTTPServer(cm *ClusterManager, lb *LoadBalancer, port int) *HTTPServer {
-	return &HTTPServer{
-		clusterManager: cm,
-		loadBalancer:   lb,
-		port:           port,
-	}
+	http.Redirect(w, r, fmt.Sprintf("http://%s%s", leaderAddr, r.URL.RequestURI()), http.StatusTemporaryRedirect)
+	return true
 }
 
 func (hs *HTTPServer) Start() error {
@@ -123,16 +74,42 @@ func (hs *HTTPServer) Start() error {
 
 	mux.HandleFunc("/api/cluster/status", hs.clusterStatusHandler)
 	mux.HandleFunc("/api/cluster/nodes", hs.clusterNodesHandler)
-	mux.HandleFunc("/api/cluster/register", hs.registerNodeHandler)
+	mux.HandleFunc("/api/cluster/register", hs.adminGuarded(hs.registerNodeHandler))
+	mux.HandleFunc("/api/cluster/deregister", hs.adminGuarded(hs.deregisterNodeHandler))
+	mux.HandleFunc("/api/cluster/events", hs.clusterEventsHandler)
+	mux.HandleFunc("/api/cluster/join", hs.adminGuarded(hs.clusterJoinHandler))
+	mux.HandleFunc("/api/cluster/leave", hs.adminGuarded(hs.clusterLeaveHandler))
 	mux.HandleFunc("/api/balancer/status", hs.balancerStatusHandler)
+	mux.HandleFunc("/api/balancer/strategy", hs.balancerStrategyHandler)
 
 	mux.HandleFunc("/", hs.proxyHandler)
 
 	addr := fmt.Sprintf(":%d", hs.port)
-	log.Printf("🚀 HTTP сервер запущен на порту %d", hs.port)
+
+	if hs.security != nil && hs.security.TLSEnabled() {
+		tlsConfig, err := hs.security.TLSConfig()
+		if err != nil {
+			return err
+		}
+		server := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfig}
+		logger.Info("HTTP сервер запущен с TLS", "port", hs.port, "require_mtls", hs.security.RequireMTLS)
+		return server.ListenAndServeTLS("", "")
+	}
+
+	logger.Info("HTTP сервер запущен", "port", hs.port)
 	return http.ListenAndServe(addr, mux)
 }
 
+// adminGuarded оборачивает handler в SecurityConfig.AdminAuth, если
+// security настроена; иначе пропускает запрос без изменений, сохраняя
+// текущее поведение там, где control plane security не включена.
+func (hs *HTTPServer) adminGuarded(next http.HandlerFunc) http.HandlerFunc {
+	if hs.security == nil {
+		return next
+	}
+	return hs.security.AdminAuth(next)
+}
+
 func (hs *HTTPServer) clusterStatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -167,6 +144,9 @@ func (hs *HTTPServer) registerNodeHandler(w http.ResponseWriter, r *http.Request
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if hs.redirectToLeader(w, r) {
+		return
+	}
 
 	var req struct {
 		ID      string `json:"id"`
@@ -179,6 +159,13 @@ func (hs *HTTPServer) registerNodeHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if hs.security != nil {
+		if err := hs.security.CheckNodeIdentity(req.ID, NodeIdentityFromTLS(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
 	if err := hs.clusterManager.RegisterNode(req.ID, req.Address, req.Port); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -188,199 +175,286 @@ func (hs *HTTPServer) registerNodeHandler(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(map[string]string{"status": "registered"})
 }
 
-func (hs *HTTPServer) balancerStatusHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+func (hs *HTTPServer) deregisterNodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-
-	status := map[string]interface{}{
-		"strategy":      "round_robin",
-		"active_nodes":  len(hs.clusterManager.GetActiveNodes()),
-		"current_index": hs.loadBalancer.currentIndex,
+	if hs.redirectToLeader(w, r) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
-}
-
-func (hs *HTTPServer) proxyHandler(w http.ResponseWriter, r *http.Request) {
-	node := hs.loadBalancer.GetNextNode()
-	if node == nil {
-		http.Error(w, "No available nodes", http.StatusServiceUnavailable)
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	targetURL := fmt.Sprintf("http://%s:%d%s", node.Address, node.Port, r.URL.Path)
-
-	response := map[string]interface{}{
-		"message": "Request proxied to node",
-		"node": map[string]interface{}{
-			"id":      node.ID,
-			"address": node.Address,
-			"port":    node.Port,
-			"load":    node.Load,
-		},
-		"target_url": targetURL,
+	if err := hs.clusterManager.DeregisterNode(req.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(map[string]string{"status": "deregistered"})
 }
 
-type SocketServer struct {
-	clusterManager *ClusterManager
-	port           int
-}
-
-func NewSocketServer(cm *ClusterManager, port int) *SocketServer {
-	return &SocketServer{
-		clusterManager: cm,
-		port:           port,
+// clusterJoinHandler добавляет мастер-peer'а голосующим членом
+// реплицированного кластера. Требует, чтобы эта нода была текущим
+// лидером (иначе клиенту нужно самому повторить запрос на лидера).
+func (hs *HTTPServer) clusterJoinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-}
-
-func (ss *SocketServer) Start() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", ss.port))
-	if err != nil {
-		return err
+	if hs.raftNode == nil {
+		http.Error(w, "Raft membership is not enabled on this master", http.StatusNotImplemented)
+		return
+	}
+	if hs.redirectToLeader(w, r) {
+		return
 	}
-	defer listener.Close()
-
-	log.Printf("🔌 Сокет сервер запущен на порту %d", ss.port)
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Printf("❌ Ошибка принятия соединения: %v", err)
-			continue
-		}
+	var req struct {
+		NodeID   string `json:"node_id"`
+		RaftAddr string `json:"raft_addr"`
+		HTTPAddr string `json:"http_addr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
 
-		go ss.handleConnection(conn)
+	if err := hs.raftNode.Join(req.NodeID, req.RaftAddr, req.HTTPAddr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-}
 
-func (ss *SocketServer) handleConnection(conn net.Conn) {
-	defer conn.Close()
+	json.NewEncoder(w).Encode(map[string]string{"status": "joined"})
+}
 
-	log.Printf("🔗 Новое соединение от %s", conn.RemoteAddr())
+func (hs *HTTPServer) clusterLeaveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hs.raftNode == nil {
+		http.Error(w, "Raft membership is not enabled on this master", http.StatusNotImplemented)
+		return
+	}
+	if hs.redirectToLeader(w, r) {
+		return
+	}
 
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
-	if err != nil {
-		log.Printf("❌ Ошибка чтения: %v", err)
+	var req struct {
+		NodeID string `json:"node_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	var msg map[string]interface{}
-	if err := json.Unmarshal(buffer[:n], &msg); err != nil {
-		log.Printf("❌ Ошибка парсинга JSON: %v", err)
+	if err := hs.raftNode.Leave(req.NodeID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	ss.handleMessage(msg, conn)
+	json.NewEncoder(w).Encode(map[string]string{"status": "left"})
 }
 
-func (ss *SocketServer) handleMessage(msg map[string]interface{}, conn net.Conn) {
-	msgType, ok := msg["type"].(string)
+// clusterEventsHandler отдаёт поток событий жизненного цикла нод (registered/
+// healthy/unhealthy/removed) через Server-Sent Events.
+func (hs *HTTPServer) clusterEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
 	if !ok {
-		log.Printf("❌ Неизвестный тип сообщения")
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
 
-	switch msgType {
-	case "register":
-		ss.handleRegister(msg, conn)
-	case "heartbeat":
-		ss.handleHeartbeat(msg, conn)
-	case "load_update":
-		ss.handleLoadUpdate(msg, conn)
-	default:
-		log.Printf("❌ Неизвестный тип сообщения: %s", msgType)
+	events, unsubscribe := hs.clusterManager.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload)
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
 	}
 }
 
-func (ss *SocketServer) handleRegister(msg map[string]interface{}, conn net.Conn) {
-	id, _ := msg["id"].(string)
-	address, _ := msg["address"].(string)
-	port, _ := msg["port"].(float64)
-
-	if id == "" || address == "" || port == 0 {
-		log.Printf("❌ Неполные данные регистрации")
+func (hs *HTTPServer) balancerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	remoteAddr := conn.RemoteAddr().String()
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err == nil && host != "" {
-		address = host
+	nodes := hs.clusterManager.GetActiveNodes()
+	counters := make([]map[string]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		// ResponseEWMA пишется под lb.mutex (RecordResponseTime), читаем под ним же.
+		hs.loadBalancer.mutex.Lock()
+		ewma := node.ResponseEWMA
+		hs.loadBalancer.mutex.Unlock()
+
+		counters = append(counters, map[string]interface{}{
+			"id":            node.ID,
+			"in_flight":     atomic.LoadInt64(&node.InFlight),
+			"response_ewma": ewma,
+		})
 	}
 
-	err = ss.clusterManager.RegisterNode(id, address, int(port))
-	if err != nil {
-		log.Printf("❌ Ошибка регистрации ноды: %v", err)
-		return
+	status := map[string]interface{}{
+		"strategy":      hs.loadBalancer.StrategyName(),
+		"active_nodes":  len(nodes),
+		"current_index": hs.loadBalancer.CurrentIndex(),
+		"nodes":         counters,
 	}
 
-	response := map[string]string{"status": "registered"}
-	responseBytes, _ := json.Marshal(response)
-	conn.Write(responseBytes)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
 
-	log.Printf("✅ Нода %s успешно зарегистрирована", id)
+func (hs *HTTPServer) balancerStrategyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"strategy": hs.loadBalancer.StrategyName()})
+
+	case http.MethodPut:
+		var req struct {
+			Strategy string `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if err := hs.loadBalancer.SetStrategy(req.Strategy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"strategy": hs.loadBalancer.StrategyName()})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
 }
 
-func (ss *SocketServer) handleHeartbeat(msg map[string]interface{}, conn net.Conn) {
-	id, _ := msg["id"].(string)
-	if id == "" {
+func (hs *HTTPServer) proxyHandler(w http.ResponseWriter, r *http.Request) {
+	node := hs.loadBalancer.Pick(r)
+	if node == nil {
+		http.Error(w, "No available nodes", http.StatusServiceUnavailable)
 		return
 	}
-
-	ss.clusterManager.mutex.Lock()
-	if node, exists := ss.clusterManager.nodes[id]; exists {
-		node.LastSeen = time.Now()
+	if m := currentMetrics(); m != nil {
+		m.RecordBalancerDecision(hs.loadBalancer.StrategyName())
 	}
-	ss.clusterManager.mutex.Unlock()
 
-	response := map[string]string{"status": "ok"}
-	responseBytes, _ := json.Marshal(response)
-	conn.Write(responseBytes)
-}
+	if isWebsocketUpgrade(r) {
+		logger.Info("апгрейд до websocket проксируется на ноду", "node_id", node.ID)
+	}
 
-func (ss *SocketServer) handleLoadUpdate(msg map[string]interface{}, conn net.Conn) {
-	id, _ := msg["id"].(string)
-	load, _ := msg["load"].(float64)
+	r, endSpan := startProxySpan(r, node.ID)
+	defer endSpan()
+	injectTraceparent(r)
 
-	if id == "" {
-		return
+	if m := currentMetrics(); m != nil {
+		m.IncActiveConnections()
+		defer m.DecActiveConnections()
 	}
 
-	err := ss.clusterManager.UpdateNodeLoad(id, int(load))
-	if err != nil {
-		log.Printf("❌ Ошибка обновления нагрузки: %v", err)
-		return
-	}
+	atomic.AddInt64(&node.InFlight, 1)
+	started := time.Now()
+
+	hs.reverseProxy.Proxy(w, r, node, hs.loadBalancer.PickExcluding)
 
-	response := map[string]string{"status": "updated"}
-	responseBytes, _ := json.Marshal(response)
-	conn.Write(responseBytes)
+	elapsed := time.Since(started)
+	atomic.AddInt64(&node.InFlight, -1)
+	hs.loadBalancer.RecordResponseTime(node, elapsed)
+	if m := currentMetrics(); m != nil {
+		m.RecordProxied(node.ID, elapsed)
+	}
 }
 
 func main() {
-	log.Println("🚀 Запуск центрального сервера...")
+	logger.Info("запуск центрального сервера")
 
 	clusterManager := NewClusterManager()
 
+	healthChecker := NewHealthChecker(clusterManager, DefaultHealthCheckConfig())
+	healthChecker.Start()
+
 	loadBalancer := NewLoadBalancer(clusterManager)
 
 	httpServer := NewHTTPServer(clusterManager, loadBalancer, 8080)
+
+	if nodeID := os.Getenv("MASTER_RAFT_NODE_ID"); nodeID != "" {
+		raftConfig := DefaultRaftConfig(nodeID, os.Getenv("MASTER_RAFT_BIND_ADDR"), os.Getenv("MASTER_RAFT_DATA_DIR"))
+		raftConfig.Bootstrap = os.Getenv("MASTER_RAFT_BOOTSTRAP") == "true"
+
+		raftNode, err := NewRaftNode(raftConfig, clusterManager)
+		if err != nil {
+			logger.Error("не удалось поднять Raft-узел", "error", err)
+			os.Exit(1)
+		}
+		clusterManager.SetStore(raftNode)
+		httpServer.SetRaftNode(raftNode)
+		logger.Info("Raft membership включён", "node_id", raftConfig.NodeID, "bind", raftConfig.BindAddr)
+	}
+
+	securityConfig, err := LoadSecurityConfig(os.Getenv("MASTER_SECURITY_CONFIG"))
+	if err != nil {
+		logger.Error("не удалось загрузить конфигурацию безопасности", "error", err)
+		os.Exit(1)
+	}
+	httpServer.SetSecurity(securityConfig)
+
+	metricsConfig := DefaultMetricsConfig()
+	if os.Getenv("MASTER_METRICS_ENABLED") == "true" {
+		metricsConfig.Enabled = true
+		if addr := os.Getenv("MASTER_METRICS_ADDR"); addr != "" {
+			metricsConfig.Addr = addr
+		}
+	}
+	if metricsConfig.Enabled {
+		go func() {
+			if err := StartMetricsServer(metricsConfig); err != nil {
+				logger.Error("ошибка сервера метрик", "error", err)
+			}
+		}()
+	}
+
 	go func() {
 		if err := httpServer.Start(); err != nil {
-			log.Fatalf("❌ Ошибка HTTP сервера: %v", err)
+			logger.Error("ошибка HTTP сервера", "error", err)
+			os.Exit(1)
 		}
 	}()
 
 	socketServer := NewSocketServer(clusterManager, 8081)
+	socketServer.SetSecurity(securityConfig)
 	if err := socketServer.Start(); err != nil {
-		log.Fatalf("❌ Ошибка сокет сервера: %v", err)
+		logger.Error("ошибка сокет сервера", "error", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsConfig настраивает эндпоинт /metrics. По умолчанию метрики
+// выключены, чтобы не менять поведение процесса там, где их никто не
+// собирает.
+type MetricsConfig struct {
+	Enabled bool
+	Addr    string // например ":9090"; слушается отдельно от публичного HTTP
+}
+
+func DefaultMetricsConfig() *MetricsConfig {
+	return &MetricsConfig{
+		Enabled: false,
+		Addr:    ":9090",
+	}
+}
+
+// Metrics собирает все Prometheus-метрики кластера и прокси в одном
+// месте. Поля экспортируются через promauto при старте процесса, поэтому
+// повторно регистрировать их не нужно.
+type Metrics struct {
+	requestsProxied   *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	activeConnections prometheus.Gauge
+	nodeHealthFlaps   *prometheus.CounterVec
+	socketFrames      *prometheus.CounterVec
+	balancerDecisions *prometheus.CounterVec
+	clusterSize       prometheus.Gauge
+}
+
+// NewMetrics регистрирует метрики в переданном реестре.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	factory := promauto.With(reg)
+	return &Metrics{
+		requestsProxied: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "websrv_requests_proxied_total",
+			Help: "Число запросов, проксированных на ноду.",
+		}, []string{"node_id"}),
+		requestDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "websrv_request_duration_seconds",
+			Help:    "Длительность проксируемого запроса.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"node_id"}),
+		activeConnections: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "websrv_active_connections",
+			Help: "Число запросов, находящихся в обработке прямо сейчас.",
+		}),
+		nodeHealthFlaps: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "websrv_node_health_flaps_total",
+			Help: "Число переходов ноды между healthy/unhealthy.",
+		}, []string{"node_id"}),
+		socketFrames: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "websrv_socket_frames_total",
+			Help: "Число полученных кадров сокет-протокола по типу.",
+		}, []string{"frame_type"}),
+		balancerDecisions: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "websrv_balancer_decisions_total",
+			Help: "Число решений балансировщика по стратегии.",
+		}, []string{"strategy"}),
+		clusterSize: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "websrv_cluster_size",
+			Help: "Текущее число зарегистрированных нод.",
+		}),
+	}
+}
+
+func (m *Metrics) RecordProxied(nodeID string, d time.Duration) {
+	m.requestsProxied.WithLabelValues(nodeID).Inc()
+	m.requestDuration.WithLabelValues(nodeID).Observe(d.Seconds())
+}
+
+func (m *Metrics) IncActiveConnections() { m.activeConnections.Inc() }
+func (m *Metrics) DecActiveConnections() { m.activeConnections.Dec() }
+
+func (m *Metrics) RecordNodeHealthFlap(nodeID string) {
+	m.nodeHealthFlaps.WithLabelValues(nodeID).Inc()
+}
+
+func (m *Metrics) RecordSocketFrame(frameType string) {
+	m.socketFrames.WithLabelValues(frameType).Inc()
+}
+
+func (m *Metrics) RecordBalancerDecision(strategy string) {
+	m.balancerDecisions.WithLabelValues(strategy).Inc()
+}
+
+func (m *Metrics) SetClusterSize(n int) {
+	m.clusterSize.Set(float64(n))
+}
+
+// metricsPtr — глобальный сборщик метрик пакета master, опубликованный
+// через atomic.Pointer. StartMetricsServer пишет в него из отдельной
+// горутины (см. main), а Record*/Inc*/Dec* вызываются из горутин
+// HTTP/сокет-серверов — обычная переменная-указатель здесь была бы гонкой
+// данных между первой и остальными. currentMetrics остаётся nil, пока
+// main не включит метрики через MetricsConfig.Enabled, поэтому все
+// вызовы вокруг кода обёрнуты проверкой на nil.
+var metricsPtr atomic.Pointer[Metrics]
+
+func currentMetrics() *Metrics {
+	return metricsPtr.Load()
+}
+
+// StartMetricsServer поднимает отдельный HTTP-листенер с /metrics —
+// вынесен на отдельный адрес, чтобы не примешивать служебный эндпоинт к
+// публичному HTTP-серверу.
+func StartMetricsServer(config *MetricsConfig) error {
+	reg := prometheus.NewRegistry()
+	metricsPtr.Store(NewMetrics(reg))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	logger.Info("метрики Prometheus включены", "addr", config.Addr)
+	return http.ListenAndServe(config.Addr, mux)
+}
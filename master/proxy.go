@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyConfig настраивает поведение обратного прокси: пул соединений,
+// предохранитель и политику повторов.
+type ProxyConfig struct {
+	InitialPoolSize   int
+	MaxPoolCapacity   int
+	DialTimeout       time.Duration
+	ResponseTimeout   time.Duration
+	MaxRetries        int
+	BreakerThreshold  int           // число последовательных ошибок до открытия breaker'а
+	BreakerCooldown   time.Duration // сколько breaker остаётся открытым перед half-open
+	IdempotentMethods map[string]bool
+}
+
+// DefaultProxyConfig возвращает набор настроек, разумных по умолчанию.
+func DefaultProxyConfig() *ProxyConfig {
+	return &ProxyConfig{
+		InitialPoolSize:  4,
+		MaxPoolCapacity:  32,
+		DialTimeout:      5 * time.Second,
+		ResponseTimeout:  30 * time.Second,
+		MaxRetries:       2,
+		BreakerThreshold: 5,
+		BreakerCooldown:  10 * time.Second,
+		IdempotentMethods: map[string]bool{
+			http.MethodGet:     true,
+			http.MethodHead:    true,
+			http.MethodOptions: true,
+			http.MethodPut:     true,
+			http.MethodDelete:  true,
+		},
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker отслеживает состояние апстрима по одной ноде:
+// закрыт (пропускает трафик), открыт (трафик отклоняется) или
+// полуоткрыт (пробный запрос разрешён для проверки восстановления).
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+func (cb *circuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) >= cb.cooldown {
+			cb.state = breakerHalfOpen
+			return true
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func (cb *circuitBreaker) RecordSuccess() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.failures = 0
+	cb.state = breakerClosed
+}
+
+func (cb *circuitBreaker) RecordFailure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+func (cb *circuitBreaker) Open() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.state == breakerOpen && time.Since(cb.openedAt) < cb.cooldown
+}
+
+// nodeTransport — пул переиспользуемых *http.Transport на ноду, чтобы не
+// пересоздавать TCP-соединения на каждый запрос.
+type nodeTransport struct {
+	transport *http.Transport
+	breaker   *circuitBreaker
+}
+
+// ReverseProxyManager строит и кеширует httputil.ReverseProxy на ноду,
+// с ограниченным пулом соединений и предохранителем per-node.
+type ReverseProxyManager struct {
+	config *ProxyConfig
+
+	mutex      sync.RWMutex
+	transports map[string]*nodeTransport
+}
+
+func NewReverseProxyManager(config *ProxyConfig) *ReverseProxyManager {
+	if config == nil {
+		config = DefaultProxyConfig()
+	}
+	return &ReverseProxyManager{
+		config:     config,
+		transports: make(map[string]*nodeTransport),
+	}
+}
+
+func (rpm *ReverseProxyManager) forNode(node *Node) *nodeTransport {
+	rpm.mutex.RLock()
+	nt, exists := rpm.transports[node.ID]
+	rpm.mutex.RUnlock()
+	if exists {
+		return nt
+	}
+
+	rpm.mutex.Lock()
+	defer rpm.mutex.Unlock()
+	if nt, exists = rpm.transports[node.ID]; exists {
+		return nt
+	}
+
+	nt = &nodeTransport{
+		transport: &http.Transport{
+			MaxIdleConnsPerHost: rpm.config.MaxPoolCapacity,
+			MaxConnsPerHost:     rpm.config.MaxPoolCapacity,
+			IdleConnTimeout:     90 * time.Second,
+			DialContext: (&net.Dialer{
+				Timeout: rpm.config.DialTimeout,
+			}).DialContext,
+			// ResponseHeaderTimeout ограничивает только ожидание заголовков
+			// ответа, а не чтение тела — иначе долгоживущий SSE/chunked
+			// стрим обрывался бы на ResponseTimeout, даже если апстрим
+			// исправно шлёт данные.
+			ResponseHeaderTimeout: rpm.config.ResponseTimeout,
+		},
+		breaker: newCircuitBreaker(rpm.config.BreakerThreshold, rpm.config.BreakerCooldown),
+	}
+	rpm.transports[node.ID] = nt
+	return nt
+}
+
+// BreakerOpen сообщает, заблокирована ли нода предохранителем прямо сейчас.
+// Используется LoadBalancer'ом, чтобы не выбирать заведомо недоступные ноды.
+func (rpm *ReverseProxyManager) BreakerOpen(node *Node) bool {
+	nt := rpm.forNode(node)
+	return nt.breaker.Open()
+}
+
+// singleHostProxy создаёт ReverseProxy, нацеленный на адрес ноды, с
+// прокинутыми X-Forwarded-For/X-Real-IP и поддержкой апгрейда websocket'ов
+// (стандартный httputil.ReverseProxy сам проксирует Connection: Upgrade).
+func (rpm *ReverseProxyManager) singleHostProxy(node *Node, nt *nodeTransport) *httputil.ReverseProxy {
+	target := &url.URL{Scheme: "http", Host: fmt.Sprintf("%s:%d", node.Address, node.Port)}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = nt.transport
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Host = target.Host
+
+		if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+				req.Header.Set("X-Forwarded-For", prior+", "+clientIP)
+			} else {
+				req.Header.Set("X-Forwarded-For", clientIP)
+			}
+			req.Header.Set("X-Real-IP", clientIP)
+		}
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		nt.breaker.RecordFailure()
+		http.Error(w, "Upstream error: "+err.Error(), http.StatusBadGateway)
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode >= 500 {
+			nt.breaker.RecordFailure()
+		} else {
+			nt.breaker.RecordSuccess()
+		}
+		return nil
+	}
+
+	return proxy
+}
+
+// Proxy пересылает запрос на node и, при ошибке апстрима или 5xx на
+// идемпотентном методе, повторяет попытку на следующей ноде из nextNode.
+// Апгрейды протокола (websocket) ретраить нельзя — соединение становится
+// stateful сразу после ответа, поэтому они обслуживаются напрямую через w,
+// без буферизующей обёртки и без повторов.
+func (rpm *ReverseProxyManager) Proxy(w http.ResponseWriter, r *http.Request, node *Node, nextNode func(exclude map[string]bool) *Node) {
+	if isWebsocketUpgrade(r) {
+		if node == nil {
+			http.Error(w, "No available nodes", http.StatusServiceUnavailable)
+			return
+		}
+		nt := rpm.forNode(node)
+		rpm.singleHostProxy(node, nt).ServeHTTP(w, r)
+		return
+	}
+
+	body, err := bufferRequestBody(r)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	tried := map[string]bool{}
+	attempts := rpm.config.MaxRetries + 1
+
+	for i := 0; i < attempts && node != nil; i++ {
+		tried[node.ID] = true
+
+		nt := rpm.forNode(node)
+		if !nt.breaker.Allow() {
+			node = nextNode(tried)
+			continue
+		}
+
+		rewindRequestBody(r, body)
+
+		rec := &statusRecordingWriter{ResponseWriter: w}
+		proxy := rpm.singleHostProxy(node, nt)
+
+		// Таймаут на получение заголовков уже наложен на транспорт
+		// (ResponseHeaderTimeout в forNode) — здесь ServeHTTP запускается
+		// с контекстом исходного запроса без дополнительного дедлайна,
+		// чтобы не обрывать стриминг тела ответа.
+		proxy.ServeHTTP(rec, r)
+
+		if rec.status >= 500 && rpm.config.IdempotentMethods[r.Method] && i < attempts-1 {
+			if next := nextNode(tried); next != nil {
+				node = next
+				continue
+			}
+		}
+
+		// Ретраев больше не будет: либо ответ уже успешно ушёл клиенту
+		// (status < 500, rec.replay — no-op), либо это последний шанс
+		// отдать клиенту реальный ответ апстрима вместо того, чтобы его
+		// проглотить.
+		rec.replay()
+		return
+	}
+
+	http.Error(w, "No available nodes", http.StatusServiceUnavailable)
+}
+
+// bufferRequestBody читает и буферизует тело запроса целиком, чтобы его
+// можно было воспроизвести на каждой попытке ретрая — httputil.ReverseProxy
+// потребляет r.Body при каждом ServeHTTP, и без этого повторный PUT/DELETE
+// дошёл бы до следующей ноды с пустым телом.
+func bufferRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// rewindRequestBody восстанавливает r.Body из буфера перед очередной
+// попыткой — не делает ничего, если тела не было (GET/HEAD и т.п.).
+func rewindRequestBody(r *http.Request, body []byte) {
+	if body == nil {
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+}
+
+// statusRecordingWriter буферизует заголовки и, при 5xx, тело ответа вместо
+// немедленной отправки клиенту — это даёт Proxy возможность либо повторить
+// запрос на другой ноде, либо, когда ретраи исчерпаны, передать клиенту
+// настоящий ответ апстрима через replay(). Ответы со статусом < 500
+// буферизуют только заголовки (тело проходит насквозь, чтобы стриминг —
+// SSE, большие тела — не ломался).
+//
+// Header() намеренно возвращает отдельную scratch-карту, а не
+// ResponseWriter.Header() напрямую: httputil.ReverseProxy копирует в неё
+// заголовки апстрима аддитивно (copyHeader), и при ретрае на следующую
+// ноду новый statusRecordingWriter с тем же w получил бы эту карту уже
+// заполненной заголовками провалившейся попытки — клиент в итоге получил бы
+// задвоенные/чужие заголовки (два Content-Length, Set-Cookie с упавшей
+// ноды). Держа заголовки каждой попытки в своей карте и перенося в w
+// только заголовки победившей попытки, повторные попытки друг друга не
+// видят.
+//
+// Flush/Hijack пробрасываются к исходному http.ResponseWriter: без этого
+// обёртка ломает апгрейд протокола ("can't switch protocols using
+// non-Hijacker ResponseWriter") и инкрементальную отправку потоковых
+// ответов. Proxy уже обслуживает websocket-апгрейды в обход этой обёртки,
+// но проброс оставлен как защита от случаев, которые isWebsocketUpgrade не
+// распознаёт.
+type statusRecordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	buf    bytes.Buffer
+}
+
+func (w *statusRecordingWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *statusRecordingWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status < 500 {
+		w.commitHeader()
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *statusRecordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status >= 500 {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// commitHeader переносит заголовки этой попытки в реальный
+// http.ResponseWriter — вызывается только для той попытки, чей ответ
+// действительно уходит клиенту (успешной сразу или через replay).
+func (w *statusRecordingWriter) commitHeader() {
+	dst := w.ResponseWriter.Header()
+	for k, v := range w.header {
+		dst[k] = v
+	}
+}
+
+// replay отправляет клиенту буферизованный 5xx-ответ апстрима. Не делает
+// ничего для статусов < 500, которые уже ушли клиенту напрямую из Write.
+func (w *statusRecordingWriter) replay() {
+	if w.status < 500 {
+		return
+	}
+	w.commitHeader()
+	w.ResponseWriter.WriteHeader(w.status)
+	w.buf.WriteTo(w.ResponseWriter)
+}
+
+// Flush пробрасывается к исходному http.ResponseWriter, чтобы потоковые
+// ответы (SSE, chunked) продолжали флашиться через обёртку.
+func (w *statusRecordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack пробрасывается к исходному http.ResponseWriter — httputil.ReverseProxy
+// хиджекает соединение при апгрейде протокола.
+func (w *statusRecordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("proxy: ResponseWriter не поддерживает hijacking")
+	}
+	return hj.Hijack()
+}
+
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Connection"), "Upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
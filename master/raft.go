@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// RaftConfig настраивает реплицированный кластер membership-мастеров.
+type RaftConfig struct {
+	NodeID       string // уникальный ID этого мастера среди peer'ов
+	BindAddr     string // адрес для Raft-транспорта (host:port)
+	DataDir      string // каталог для журнала и снапшотов BoltDB
+	Bootstrap    bool   // true только для самого первого узла нового кластера
+	ApplyTimeout time.Duration
+}
+
+func DefaultRaftConfig(nodeID, bindAddr, dataDir string) *RaftConfig {
+	return &RaftConfig{
+		NodeID:       nodeID,
+		BindAddr:     bindAddr,
+		DataDir:      dataDir,
+		ApplyTimeout: 5 * time.Second,
+	}
+}
+
+// RaftNode оборачивает *raft.Raft и даёт мастеру знать, кто лидер, чтобы
+// редиректить на него мутирующие HTTP-запросы.
+type RaftNode struct {
+	raft   *raft.Raft
+	config *RaftConfig
+
+	// peerHTTPAddr хранит HTTP-адрес каждого известного peer'а (по его
+	// Raft node ID), чтобы на 307-редиректе неleader'а можно было указать
+	// Location, а не просто адрес Raft-транспорта.
+	peerHTTPAddr map[string]string
+}
+
+// NewRaftNode поднимает Raft-узел поверх BoltDB-хранилища журнала и
+// файлового снапшот-стора, по образцу rqlite: один процесс на мастер,
+// состояние кластера членства реплицируется через журнал, а не
+// раздаётся отдельными heartbeat-сообщениями.
+func NewRaftNode(config *RaftConfig, cm *ClusterManager) (*RaftNode, error) {
+	if err := os.MkdirAll(config.DataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("raft: не удалось создать каталог данных: %w", err)
+	}
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = raft.ServerID(config.NodeID)
+
+	addr, err := net.ResolveTCPAddr("tcp", config.BindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("raft: не удалось разобрать bind-адрес: %w", err)
+	}
+	transport, err := raft.NewTCPTransport(config.BindAddr, addr, 3, 10*time.Second, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("raft: не удалось поднять TCP-транспорт: %w", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(config.DataDir, 2, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("raft: не удалось поднять snapshot store: %w", err)
+	}
+
+	boltStore, err := raftboltdb.NewBoltStore(filepath.Join(config.DataDir, "raft.db"))
+	if err != nil {
+		return nil, fmt.Errorf("raft: не удалось поднять BoltDB store: %w", err)
+	}
+
+	fsm := &clusterFSM{cm: cm}
+
+	r, err := raft.NewRaft(raftConfig, fsm, boltStore, boltStore, snapshots, transport)
+	if err != nil {
+		return nil, fmt.Errorf("raft: не удалось создать Raft-узел: %w", err)
+	}
+
+	if config.Bootstrap {
+		bootstrapConfig := raft.Configuration{
+			Servers: []raft.Server{
+				{ID: raftConfig.LocalID, Address: transport.LocalAddr()},
+			},
+		}
+		if err := r.BootstrapCluster(bootstrapConfig).Error(); err != nil {
+			return nil, fmt.Errorf("raft: не удалось забутстрапить кластер: %w", err)
+		}
+	}
+
+	return &RaftNode{raft: r, config: config, peerHTTPAddr: make(map[string]string)}, nil
+}
+
+func (rn *RaftNode) IsLeader() bool {
+	return rn.raft.State() == raft.Leader
+}
+
+// LeaderAddress возвращает HTTP-адрес текущего лидера, если он известен
+// (через предыдущий Join), иначе — его Raft bind-адрес как приблизительную
+// подсказку.
+func (rn *RaftNode) LeaderAddress() string {
+	_, leaderID := rn.raft.LeaderWithID()
+	if leaderID == "" {
+		return ""
+	}
+	if httpAddr, ok := rn.peerHTTPAddr[string(leaderID)]; ok {
+		return httpAddr
+	}
+	addr, _ := rn.raft.LeaderWithID()
+	return string(addr)
+}
+
+// Join добавляет peer'а голосующим членом Raft-кластера. Вызывается
+// только на текущем лидере — /api/cluster/join делегирует сюда, предварительно
+// отредиректив на лидера при необходимости.
+func (rn *RaftNode) Join(nodeID, raftAddr, httpAddr string) error {
+	if !rn.IsLeader() {
+		return ErrNotLeader
+	}
+
+	future := rn.raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), 0, 0)
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("raft: не удалось присоединить %s: %w", nodeID, err)
+	}
+
+	rn.peerHTTPAddr[nodeID] = httpAddr
+	return nil
+}
+
+// Leave выводит peer'а из кластера членства мастеров.
+func (rn *RaftNode) Leave(nodeID string) error {
+	if !rn.IsLeader() {
+		return ErrNotLeader
+	}
+
+	future := rn.raft.RemoveServer(raft.ServerID(nodeID), 0, 0)
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("raft: не удалось вывести %s: %w", nodeID, err)
+	}
+
+	delete(rn.peerHTTPAddr, nodeID)
+	return nil
+}
+
+// Propose реализует Store: предлагает команду через журнал Raft и
+// дожидается её применения на большинстве. На не-лидере сразу возвращает
+// ErrNotLeader, не трогая журнал.
+func (rn *RaftNode) Propose(cmd Command) error {
+	if !rn.IsLeader() {
+		return ErrNotLeader
+	}
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	future := rn.raft.Apply(data, rn.config.ApplyTimeout)
+	return future.Error()
+}
+
+var _ Store = (*RaftNode)(nil)
+
+// clusterFSM — Raft FSM, реплицирующий мутации ClusterManager на все
+// реплики одинаково: лидер проводит команду через журнал, а дальше
+// Apply вызывается на каждой реплике (включая лидера) в одном и том же
+// порядке.
+type clusterFSM struct {
+	cm *ClusterManager
+}
+
+func (f *clusterFSM) Apply(log *raft.Log) interface{} {
+	var cmd Command
+	if err := json.Unmarshal(log.Data, &cmd); err != nil {
+		return err
+	}
+	return f.cm.apply(cmd)
+}
+
+func (f *clusterFSM) Snapshot() (raft.FSMSnapshot, error) {
+	nodes := f.cm.AllNodes()
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterSnapshot{data: data}, nil
+}
+
+func (f *clusterFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var nodes []*Node
+	if err := json.NewDecoder(rc).Decode(&nodes); err != nil {
+		return err
+	}
+
+	f.cm.mutex.Lock()
+	f.cm.nodes = make(map[string]*Node, len(nodes))
+	for _, node := range nodes {
+		f.cm.nodes[node.ID] = node
+	}
+	f.cm.mutex.Unlock()
+	return nil
+}
+
+type clusterSnapshot struct {
+	data []byte
+}
+
+func (s *clusterSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *clusterSnapshot) Release() {}
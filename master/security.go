@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SecurityConfig описывает контроль доступа к control plane: TLS/mTLS на
+// HTTP и сокетном листенерах, HMAC-аутентификацию кадров регистрации и
+// heartbeat, и bearer-токен для мутирующих ручек /api/cluster/*.
+// Загружается из YAML-файла (см. LoadSecurityConfig), поля которого можно
+// переопределить переменными окружения — по тому же принципу, что и
+// RaftConfig/MetricsConfig в main.
+type SecurityConfig struct {
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	TLSCAFile   string `yaml:"tls_ca_file"` // непусто и RequireMTLS=true → клиентские сертификаты обязательны
+
+	RequireMTLS       bool `yaml:"require_mtls"`
+	SocketTLSRequired bool `yaml:"socket_tls_required"`
+
+	AdminToken string `yaml:"admin_token"` // bearer-токен для /api/cluster/* мутаций; пусто — проверка выключена
+
+	HMACSecret   string        `yaml:"hmac_secret"`   // общий секрет для AuthToken кадров регистрации/heartbeat; пусто — проверка выключена
+	ReplayWindow time.Duration `yaml:"replay_window"` // насколько могут расходиться часы клиента и сервера
+}
+
+func DefaultSecurityConfig() *SecurityConfig {
+	return &SecurityConfig{
+		ReplayWindow: 30 * time.Second,
+	}
+}
+
+// TLSEnabled сообщает, заданы ли сертификат и ключ для TLS-листенера.
+func (sc *SecurityConfig) TLSEnabled() bool {
+	return sc.TLSCertFile != "" && sc.TLSKeyFile != ""
+}
+
+// LoadSecurityConfig читает YAML-файл конфигурации (если path непуст и
+// файл существует) и затем применяет переменные окружения поверх него,
+// так что чувствительные значения вроде секретов и токенов можно
+// передавать без файла на диске.
+func LoadSecurityConfig(path string) (*SecurityConfig, error) {
+	config := DefaultSecurityConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("security: не удалось прочитать конфиг %s: %w", path, err)
+		}
+		if err == nil {
+			if err := yaml.Unmarshal(data, config); err != nil {
+				return nil, fmt.Errorf("security: не удалось разобрать конфиг %s: %w", path, err)
+			}
+		}
+	}
+
+	if v := os.Getenv("MASTER_TLS_CERT_FILE"); v != "" {
+		config.TLSCertFile = v
+	}
+	if v := os.Getenv("MASTER_TLS_KEY_FILE"); v != "" {
+		config.TLSKeyFile = v
+	}
+	if v := os.Getenv("MASTER_TLS_CA_FILE"); v != "" {
+		config.TLSCAFile = v
+	}
+	if os.Getenv("MASTER_REQUIRE_MTLS") == "true" {
+		config.RequireMTLS = true
+	}
+	if os.Getenv("MASTER_SOCKET_TLS_REQUIRED") == "true" {
+		config.SocketTLSRequired = true
+	}
+	if v := os.Getenv("MASTER_ADMIN_TOKEN"); v != "" {
+		config.AdminToken = v
+	}
+	if v := os.Getenv("MASTER_HMAC_SECRET"); v != "" {
+		config.HMACSecret = v
+	}
+
+	return config, nil
+}
+
+// TLSConfig строит *tls.Config для HTTP и сокетного листенеров из путей
+// к сертификату/ключу и, при RequireMTLS, доверенному CA клиентов. node
+// аутентифицируется TLS-клиентским сертификатом, чей CN становится его
+// идентичностью (см. NodeIdentityFromTLS/CheckNodeIdentity).
+func (sc *SecurityConfig) TLSConfig() (*tls.Config, error) {
+	if !sc.TLSEnabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(sc.TLSCertFile, sc.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("security: не удалось загрузить сертификат/ключ: %w", err)
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if sc.RequireMTLS {
+		if sc.TLSCAFile == "" {
+			return nil, fmt.Errorf("security: require_mtls включён, но tls_ca_file не задан")
+		}
+		caPEM, err := os.ReadFile(sc.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("security: не удалось прочитать CA %s: %w", sc.TLSCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("security: CA-файл %s не содержит валидных сертификатов", sc.TLSCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// NodeIdentityFromTLS возвращает CN клиентского сертификата как
+// идентичность рабочей ноды при mTLS, пустую строку — если соединение не
+// TLS или клиентский сертификат не предъявлен. Только CN, без SAN — этого
+// достаточно, пока ноды выпускаются с одним CN на сертификат.
+func NodeIdentityFromTLS(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+// NodeIdentityFromConn — аналог NodeIdentityFromTLS для сокетного сервера,
+// у которого нет *http.Request и который работает с net.Conn напрямую.
+func NodeIdentityFromConn(conn net.Conn) string {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.CommonName
+}
+
+// CheckNodeIdentity проверяет, что самозаявленный id ноды совпадает с её
+// TLS-идентичностью (CN клиентского сертификата), когда RequireMTLS
+// включён — иначе валидный держатель сертификата мог бы зарегистрироваться
+// под любым чужим id. Ничего не проверяет, если mTLS не требуется.
+func (sc *SecurityConfig) CheckNodeIdentity(id, identity string) error {
+	if !sc.RequireMTLS {
+		return nil
+	}
+	if identity == "" || identity != id {
+		return fmt.Errorf("security: id ноды должен совпадать с CN клиентского TLS-сертификата")
+	}
+	return nil
+}
+
+// AdminAuth — middleware, требующий bearer-токен на мутирующих ручках
+// /api/cluster/*. Если AdminToken не задан, проверка выключена — это
+// сохраняет текущее поведение для тех, кто ещё не настроил control
+// plane security.
+func (sc *SecurityConfig) AdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sc.AdminToken == "" {
+			next(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sc.AdminToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
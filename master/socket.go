@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/MRXTOOR/web-srv/pkg/wire"
+)
+
+// SocketServer слушает порт агентов и обслуживает каждое соединение
+// кадрами протокола pkg/wire до его закрытия, вместо одного JSON-сообщения
+// на соединение.
+type SocketServer struct {
+	clusterManager *ClusterManager
+	port           int
+
+	// security и frameAuth — nil, пока control plane security не
+	// настроена через main (SecurityConfig.TLSEnabled()/HMACSecret);
+	// в этом случае сокет принимает открытые соединения и
+	// неаутентифицированные register/heartbeat, как и раньше.
+	security  *SecurityConfig
+	frameAuth *FrameAuthenticator
+}
+
+func NewSocketServer(cm *ClusterManager, port int) *SocketServer {
+	return &SocketServer{
+		clusterManager: cm,
+		port:           port,
+	}
+}
+
+// SetSecurity подключает TLS/mTLS и HMAC-аутентификацию кадров к
+// сокетному серверу.
+func (ss *SocketServer) SetSecurity(security *SecurityConfig) {
+	ss.security = security
+	if security.HMACSecret != "" {
+		ss.frameAuth = NewFrameAuthenticator(security.HMACSecret, security.ReplayWindow)
+	}
+}
+
+func (ss *SocketServer) Start() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", ss.port))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	if ss.security != nil && ss.security.TLSEnabled() {
+		tlsConfig, err := ss.security.TLSConfig()
+		if err != nil {
+			return err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+		logger.Info("сокет сервер использует TLS", "require_mtls", ss.security.RequireMTLS)
+	}
+
+	logger.Info("сокет сервер запущен", "port", ss.port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Error("ошибка принятия соединения", "error", err)
+			continue
+		}
+
+		go ss.handleConnection(conn)
+	}
+}
+
+// frameConn объединяет кодек с мьютексом записи — несколько горутин не
+// пишут конкурентно в рамках одного соединения, но обработчики кадров
+// сейчас и так последовательны; мьютекс нужен на будущее (например, для
+// асинхронных RPC-ответов).
+type frameConn struct {
+	codec    *wire.Codec
+	writeMu  sync.Mutex
+	encoding wire.Encoding
+}
+
+func (fc *frameConn) writeFrame(frameType wire.FrameType, payload interface{}) error {
+	data, err := fc.encoding.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	fc.writeMu.Lock()
+	defer fc.writeMu.Unlock()
+	return fc.codec.WriteFrame(frameType, data)
+}
+
+// handleConnection обслуживает одно TCP-соединение агента: сначала
+// handshake, согласующий кодировку, затем цикл кадров вплоть до закрытия
+// соединения или ошибки протокола.
+func (ss *SocketServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	logger.Info("новое соединение", "remote_addr", conn.RemoteAddr())
+
+	codec := wire.NewCodec(conn)
+	fc := &frameConn{codec: codec, encoding: mustEncoding(wire.EncodingJSON)}
+
+	frameType, payload, err := codec.ReadFrame()
+	if err != nil {
+		logger.Error("ошибка чтения handshake", "remote_addr", conn.RemoteAddr(), "error", err)
+		return
+	}
+	if frameType != wire.FrameHandshake {
+		logger.Error("ожидался handshake, получен другой кадр", "frame_type", frameType, "remote_addr", conn.RemoteAddr())
+		return
+	}
+	if m := currentMetrics(); m != nil {
+		m.RecordSocketFrame(frameType.String())
+	}
+
+	var handshake wire.HandshakePayload
+	_ = fc.encoding.Unmarshal(payload, &handshake)
+
+	_, isTLS := conn.(*tls.Conn)
+	if ss.security != nil && ss.security.SocketTLSRequired && !isTLS {
+		logger.Error("соединение без TLS отклонено политикой", "remote_addr", conn.RemoteAddr())
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: "TLS is required on this socket"})
+		return
+	}
+
+	encoding, err := wire.EncodingByName(handshake.Encoding)
+	if err != nil {
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: err.Error()})
+		return
+	}
+
+	// Ack на handshake ещё кодируется JSON: клиент узнаёт из него
+	// выбранную кодировку и переключается на неё только после разбора.
+	if err := fc.writeFrame(wire.FrameAck, wire.AckPayload{Status: "ok", Encoding: encoding.Name()}); err != nil {
+		logger.Error("не удалось подтвердить handshake", "remote_addr", conn.RemoteAddr(), "error", err)
+		return
+	}
+	fc.encoding = encoding
+
+	for {
+		frameType, payload, err := codec.ReadFrame()
+		if err != nil {
+			logger.Info("соединение закрыто", "remote_addr", conn.RemoteAddr(), "error", err)
+			return
+		}
+		if m := currentMetrics(); m != nil {
+			m.RecordSocketFrame(frameType.String())
+		}
+
+		if !ss.dispatch(conn, fc, frameType, payload) {
+			return
+		}
+	}
+}
+
+// dispatch обрабатывает один кадр после handshake. Возвращает false, если
+// соединение нужно закрыть (протокольная ошибка).
+func (ss *SocketServer) dispatch(conn net.Conn, fc *frameConn, frameType wire.FrameType, payload []byte) bool {
+	switch frameType {
+	case wire.FrameRegister:
+		ss.handleRegister(conn, fc, payload)
+	case wire.FrameHeartbeat:
+		ss.handleHeartbeat(fc, payload)
+	case wire.FrameLoadUpdate:
+		ss.handleLoadUpdate(fc, payload)
+	default:
+		logger.Error("неизвестный тип кадра", "frame_type", frameType)
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: "unknown frame type"})
+		return false
+	}
+	return true
+}
+
+func (ss *SocketServer) handleRegister(conn net.Conn, fc *frameConn, payload []byte) {
+	var req wire.RegisterPayload
+	if err := fc.encoding.Unmarshal(payload, &req); err != nil {
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: "invalid register payload"})
+		return
+	}
+
+	if req.ID == "" || req.Address == "" || req.Port == 0 {
+		logger.Error("неполные данные регистрации")
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: "incomplete registration"})
+		return
+	}
+
+	if ss.frameAuth != nil {
+		if err := ss.frameAuth.Verify(req.ID, req.Auth); err != nil {
+			logger.Warn("отклонена неаутентифицированная регистрация", "node_id", req.ID, "error", err)
+			fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: err.Error()})
+			return
+		}
+	}
+
+	if ss.security != nil {
+		if err := ss.security.CheckNodeIdentity(req.ID, NodeIdentityFromConn(conn)); err != nil {
+			logger.Warn("отклонена регистрация: id не совпадает с TLS-идентичностью", "node_id", req.ID)
+			fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: err.Error()})
+			return
+		}
+	}
+
+	address := req.Address
+	if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil && host != "" {
+		address = host
+	}
+
+	if err := ss.clusterManager.RegisterNode(req.ID, address, req.Port); err != nil {
+		logger.Error("ошибка регистрации ноды", "node_id", req.ID, "error", err)
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: err.Error()})
+		return
+	}
+
+	fc.writeFrame(wire.FrameAck, wire.AckPayload{Status: "registered"})
+	logger.Info("нода успешно зарегистрирована", "node_id", req.ID)
+}
+
+func (ss *SocketServer) handleHeartbeat(fc *frameConn, payload []byte) {
+	var req wire.HeartbeatPayload
+	if err := fc.encoding.Unmarshal(payload, &req); err != nil || req.ID == "" {
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: "invalid heartbeat payload"})
+		return
+	}
+
+	if ss.frameAuth != nil {
+		if err := ss.frameAuth.Verify(req.ID, req.Auth); err != nil {
+			logger.Warn("отклонён неаутентифицированный heartbeat", "node_id", req.ID, "error", err)
+			fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: err.Error()})
+			return
+		}
+	}
+
+	if err := ss.clusterManager.Heartbeat(req.ID); err != nil {
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: err.Error()})
+		return
+	}
+
+	fc.writeFrame(wire.FrameAck, wire.AckPayload{Status: "ok"})
+}
+
+func (ss *SocketServer) handleLoadUpdate(fc *frameConn, payload []byte) {
+	var req wire.LoadUpdatePayload
+	if err := fc.encoding.Unmarshal(payload, &req); err != nil || req.ID == "" {
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: "invalid load_update payload"})
+		return
+	}
+
+	if err := ss.clusterManager.UpdateNodeLoad(req.ID, req.Load); err != nil {
+		logger.Error("ошибка обновления нагрузки", "node_id", req.ID, "error", err)
+		fc.writeFrame(wire.FrameError, wire.ErrorPayload{Message: err.Error()})
+		return
+	}
+
+	fc.writeFrame(wire.FrameAck, wire.AckPayload{Status: "updated"})
+}
+
+func mustEncoding(name wire.EncodingName) wire.Encoding {
+	enc, err := wire.EncodingByName(name)
+	if err != nil {
+		panic(err)
+	}
+	return enc
+}
@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// CommandOp перечисляет виды мутаций реестра нод, которые можно провести
+// через Store. Raft-реплицированный Store прогоняет их через журнал
+// консенсуса; Store в памяти применяет их сразу же.
+type CommandOp string
+
+const (
+	OpRegister   CommandOp = "register"
+	OpDeregister CommandOp = "deregister"
+	OpUpdateLoad CommandOp = "update_load"
+	OpSetStatus  CommandOp = "set_status"
+	OpHeartbeat  CommandOp = "heartbeat"
+)
+
+// Command — одна мутация состояния кластера, пригодная для сериализации
+// в журнал Raft.
+type Command struct {
+	Op        CommandOp     `json:"op"`
+	ID        string        `json:"id"`
+	Address   string        `json:"address,omitempty"`
+	Port      int           `json:"port,omitempty"`
+	Load      int           `json:"load,omitempty"`
+	Status    string        `json:"status,omitempty"`
+	EventType NodeEventType `json:"event_type,omitempty"`
+}
+
+// ErrNotLeader возвращается Store'ом, когда мутация предложена не на
+// лидере реплицированного кластера — вызывающая сторона (HTTP-хендлер)
+// должна перенаправить запрос на текущего лидера.
+var ErrNotLeader = fmt.Errorf("master: эта нода не является лидером кластера")
+
+// Store принимает мутации реестра нод и решает, применять ли их сразу
+// (MemoryStore) или через Raft-консенсус (RaftNode).
+type Store interface {
+	Propose(cmd Command) error
+	IsLeader() bool
+	LeaderAddress() string
+}
+
+// MemoryStore применяет команды немедленно в локальный ClusterManager —
+// поведение по умолчанию, когда реплицированный кластер не настроен.
+type MemoryStore struct {
+	cm *ClusterManager
+}
+
+func NewMemoryStore(cm *ClusterManager) *MemoryStore {
+	return &MemoryStore{cm: cm}
+}
+
+func (s *MemoryStore) Propose(cmd Command) error {
+	return s.cm.apply(cmd)
+}
+
+func (s *MemoryStore) IsLeader() bool { return true }
+
+func (s *MemoryStore) LeaderAddress() string { return "" }
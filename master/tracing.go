@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer — трейсер пакета master. go.opentelemetry.io/otel возвращает
+// no-op реализацию, пока глобальный TracerProvider не настроен в main,
+// так что вызовы Start ниже безопасны даже без включённого экспортёра.
+var tracer = otel.Tracer("github.com/MRXTOOR/web-srv/master")
+
+// startProxySpan открывает спан вокруг одного проксируемого запроса и
+// возвращает обновлённый контекст вместе с функцией завершения спана.
+func startProxySpan(r *http.Request, nodeID string) (*http.Request, func()) {
+	ctx, span := tracer.Start(r.Context(), "proxy.request",
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+			attribute.String("websrv.node_id", nodeID),
+		),
+	)
+	return r.WithContext(ctx), func() { span.End() }
+}
+
+// injectTraceparent прокидывает traceparent текущего спана в заголовки
+// запроса, уходящего на апстрим-ноду, чтобы трасса не обрывалась на
+// границе прокси.
+func injectTraceparent(r *http.Request) {
+	propagation.TraceContext{}.Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+}
+
+// spanErrorf помечает текущий спан ошибкой с сообщением в духе fmt.Errorf,
+// не прерывая обработку запроса.
+func spanErrorf(r *http.Request, format string, args ...interface{}) {
+	span := trace.SpanFromContext(r.Context())
+	span.RecordError(fmt.Errorf(format, args...))
+}
@@ -0,0 +1,202 @@
+// Package agent предоставляет клиентскую библиотеку для рабочих нод,
+// подключающихся к сокетному порту мастер-сервера по протоколу pkg/wire.
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MRXTOOR/web-srv/pkg/wire"
+)
+
+// Client — соединение с мастером с точки зрения рабочей ноды: один
+// handshake при подключении, дальше Register/Heartbeat/LoadUpdate
+// переиспользуют то же соединение.
+type Client struct {
+	conn     net.Conn
+	codec    *wire.Codec
+	encoding wire.Encoding
+
+	writeMu sync.Mutex
+
+	tlsConfig *tls.Config
+
+	// hmacSecret, если задан, подписывает каждый Register/Heartbeat
+	// AuthToken'ом — см. WithHMACSecret и master.FrameAuthenticator,
+	// который эти токены проверяет.
+	hmacSecret string
+}
+
+// DialOption настраивает Client при подключении, аналогично тому, как
+// ProxyConfig/MetricsConfig настраивают соответствующие подсистемы
+// мастера — через явные опции, а не варианты Dial с разным числом
+// аргументов.
+type DialOption func(*Client)
+
+// WithTLS включает TLS на соединении с мастером; tlsConfig может быть nil
+// для TLS с настройками по умолчанию (проверка сертификата сервера по
+// системному пулу CA) или содержать клиентский сертификат для mTLS.
+func WithTLS(tlsConfig *tls.Config) DialOption {
+	return func(c *Client) {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// WithHMACSecret включает подпись Register/Heartbeat общим секретом,
+// которым также настроен master.SecurityConfig.HMACSecret.
+func WithHMACSecret(secret string) DialOption {
+	return func(c *Client) { c.hmacSecret = secret }
+}
+
+// Dial подключается к мастеру по адресу addr (host:port) и выполняет
+// handshake, запрашивая encoding (обычно wire.EncodingJSON).
+func Dial(addr string, encoding wire.EncodingName, opts ...DialOption) (*Client, error) {
+	client := &Client{}
+	for _, opt := range opts {
+		opt(client)
+	}
+
+	var conn net.Conn
+	var err error
+	if client.tlsConfig != nil {
+		conn, err = tls.Dial("tcp", addr, client.tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 5*time.Second)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("agent: не удалось подключиться к %s: %w", addr, err)
+	}
+
+	jsonEnc, _ := wire.EncodingByName(wire.EncodingJSON)
+	client.conn = conn
+	client.codec = wire.NewCodec(conn)
+	client.encoding = jsonEnc
+
+	if err := client.handshake(encoding); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *Client) handshake(encoding wire.EncodingName) error {
+	_, isTLS := c.conn.(*tls.Conn)
+	if err := c.writeFrame(wire.FrameHandshake, wire.HandshakePayload{Encoding: encoding, TLS: isTLS}); err != nil {
+		return fmt.Errorf("agent: handshake не отправлен: %w", err)
+	}
+
+	frameType, payload, err := c.codec.ReadFrame()
+	if err != nil {
+		return fmt.Errorf("agent: ответ на handshake не получен: %w", err)
+	}
+	if frameType == wire.FrameError {
+		var errPayload wire.ErrorPayload
+		c.encoding.Unmarshal(payload, &errPayload)
+		return fmt.Errorf("agent: мастер отклонил handshake: %s", errPayload.Message)
+	}
+
+	var ack wire.AckPayload
+	if err := c.encoding.Unmarshal(payload, &ack); err != nil {
+		return fmt.Errorf("agent: не удалось разобрать ack handshake'а: %w", err)
+	}
+
+	negotiated, err := wire.EncodingByName(ack.Encoding)
+	if err != nil {
+		return err
+	}
+	c.encoding = negotiated
+	return nil
+}
+
+func (c *Client) writeFrame(frameType wire.FrameType, payload interface{}) error {
+	data, err := c.encoding.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.codec.WriteFrame(frameType, data)
+}
+
+// call отправляет кадр и ждёт Ack/Error в ответ — протокол синхронный с
+// точки зрения агента (одно соединение, один запрос в моменте).
+func (c *Client) call(frameType wire.FrameType, payload interface{}) (wire.AckPayload, error) {
+	if err := c.writeFrame(frameType, payload); err != nil {
+		return wire.AckPayload{}, err
+	}
+
+	respType, respPayload, err := c.codec.ReadFrame()
+	if err != nil {
+		return wire.AckPayload{}, err
+	}
+
+	if respType == wire.FrameError {
+		var errPayload wire.ErrorPayload
+		c.encoding.Unmarshal(respPayload, &errPayload)
+		return wire.AckPayload{}, fmt.Errorf("agent: мастер вернул ошибку: %s", errPayload.Message)
+	}
+
+	var ack wire.AckPayload
+	if err := c.encoding.Unmarshal(respPayload, &ack); err != nil {
+		return wire.AckPayload{}, err
+	}
+	return ack, nil
+}
+
+// Register регистрирует текущую ноду на мастере.
+func (c *Client) Register(id, address string, port int) error {
+	_, err := c.call(wire.FrameRegister, wire.RegisterPayload{ID: id, Address: address, Port: port, Auth: c.sign(id)})
+	return err
+}
+
+// Heartbeat продлевает LastSeen ноды на мастере.
+func (c *Client) Heartbeat(id string) error {
+	_, err := c.call(wire.FrameHeartbeat, wire.HeartbeatPayload{ID: id, Auth: c.sign(id)})
+	return err
+}
+
+// sign подписывает id свежим nonce и меткой времени общим секретом из
+// WithHMACSecret. Возвращает нулевой AuthToken, если секрет не задан —
+// master.FrameAuthenticator в этом случае тоже выключен и не станет
+// проверять подпись.
+func (c *Client) sign(id string) wire.AuthToken {
+	if c.hmacSecret == "" {
+		return wire.AuthToken{}
+	}
+
+	nonceBytes := make([]byte, 16)
+	_, _ = rand.Read(nonceBytes)
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := time.Now().Unix()
+
+	h := hmac.New(sha256.New, []byte(c.hmacSecret))
+	fmt.Fprintf(h, "%s:%s:%d", id, nonce, timestamp)
+
+	return wire.AuthToken{
+		Nonce:     nonce,
+		Timestamp: timestamp,
+		HMAC:      hex.EncodeToString(h.Sum(nil)),
+	}
+}
+
+// LoadUpdate сообщает мастеру текущую нагрузку ноды.
+func (c *Client) LoadUpdate(id string, load int) error {
+	_, err := c.call(wire.FrameLoadUpdate, wire.LoadUpdatePayload{ID: id, Load: load})
+	return err
+}
+
+// Close закрывает соединение с мастером.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
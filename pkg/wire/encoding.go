@@ -0,0 +1,48 @@
+package wire
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EncodingName идентифицирует схему кодирования payload'а кадра,
+// согласуемую во время Handshake.
+type EncodingName string
+
+const (
+	EncodingJSON EncodingName = "json"
+
+	// EncodingProtobuf — бинарная кодировка payload'ов в стандартном
+	// protobuf wire-формате (см. protobufEncoding в protobuf.go). Схема
+	// не генерируется из .proto-файлов (их в репозитории нет), а
+	// прописана вручную для закрытого набора типов из messages.go.
+	EncodingProtobuf EncodingName = "protobuf"
+)
+
+// Encoding кодирует/декодирует payload кадра. Тип кадра и framing от неё
+// не зависят — она отвечает только за байты между [type] и концом кадра.
+type Encoding interface {
+	Name() EncodingName
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonEncoding struct{}
+
+func (jsonEncoding) Name() EncodingName                    { return EncodingJSON }
+func (jsonEncoding) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+func (jsonEncoding) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// EncodingByName возвращает Encoding по имени, согласованному в Handshake.
+func EncodingByName(name EncodingName) (Encoding, error) {
+	switch name {
+	case EncodingJSON, "":
+		return jsonEncoding{}, nil
+	case EncodingProtobuf:
+		return protobufEncoding{}, nil
+	default:
+		return nil, fmt.Errorf("wire: неизвестная кодировка %q", name)
+	}
+}
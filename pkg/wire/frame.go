@@ -0,0 +1,101 @@
+// Package wire реализует бинарный протокол кадров, используемый между
+// мастер-сервером и рабочими нодами на сокетном порту: каждый кадр имеет
+// вид [4-байтная длина big-endian][1-байтный тип][payload].
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FrameType перечисляет типы кадров протокола.
+type FrameType byte
+
+const (
+	FrameHandshake FrameType = iota + 1
+	FrameRegister
+	FrameHeartbeat
+	FrameLoadUpdate
+	FrameAck
+	FrameError
+	FrameRPC
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameHandshake:
+		return "handshake"
+	case FrameRegister:
+		return "register"
+	case FrameHeartbeat:
+		return "heartbeat"
+	case FrameLoadUpdate:
+		return "load_update"
+	case FrameAck:
+		return "ack"
+	case FrameError:
+		return "error"
+	case FrameRPC:
+		return "rpc"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(t))
+	}
+}
+
+// DefaultMaxFrameSize ограничивает размер payload'а одного кадра, чтобы
+// повреждённый или злонамеренный клиент не заставил нас выделить
+// неограниченный буфер.
+const DefaultMaxFrameSize = 1 << 20 // 1 MiB
+
+// Codec читает и пишет кадры в/из net.Conn (или любого io.ReadWriter).
+// Один Codec рассчитан на одно соединение и не потокобезопасен сам по
+// себе для записи — вызывающая сторона должна сериализовать вызовы
+// WriteFrame сама (см. master.frameConn).
+type Codec struct {
+	rw           io.ReadWriter
+	MaxFrameSize int
+}
+
+func NewCodec(rw io.ReadWriter) *Codec {
+	return &Codec{rw: rw, MaxFrameSize: DefaultMaxFrameSize}
+}
+
+// ReadFrame блокируется до получения полного кадра, используя io.ReadFull,
+// так что короткие чтения из-за фрагментации TCP не теряют данные.
+func (c *Codec) ReadFrame() (FrameType, []byte, error) {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(c.rw, lengthBuf[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length == 0 {
+		return 0, nil, fmt.Errorf("wire: пустой кадр")
+	}
+	if int(length) > c.MaxFrameSize {
+		return 0, nil, fmt.Errorf("wire: кадр размером %d байт превышает лимит %d", length, c.MaxFrameSize)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, body); err != nil {
+		return 0, nil, err
+	}
+
+	return FrameType(body[0]), body[1:], nil
+}
+
+// WriteFrame сериализует один кадр в одну запись в rw.
+func (c *Codec) WriteFrame(frameType FrameType, payload []byte) error {
+	if len(payload)+1 > c.MaxFrameSize {
+		return fmt.Errorf("wire: исходящий кадр размером %d байт превышает лимит %d", len(payload)+1, c.MaxFrameSize)
+	}
+
+	frame := make([]byte, 4+1+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)+1))
+	frame[4] = byte(frameType)
+	copy(frame[5:], payload)
+
+	_, err := c.rw.Write(frame)
+	return err
+}
@@ -0,0 +1,49 @@
+package wire
+
+// HandshakePayload открывает соединение: клиент объявляет, какой
+// кодировкой он хотел бы пользоваться, сервер в ответном Ack
+// подтверждает фактически выбранную (см. EncodingByName). TLS —
+// заявление клиента о том, что он подключился через TLS; сервер это
+// поле не читает и при включённом требовании TLS отклоняет handshake
+// по фактическому типу соединения (см. master.SecurityConfig.SocketTLSRequired).
+type HandshakePayload struct {
+	Encoding EncodingName `json:"encoding"`
+	TLS      bool         `json:"tls,omitempty"`
+}
+
+// AuthToken подтверждает подлинность кадра Register/Heartbeat общим
+// секретом: Nonce делает подпись одноразовой, Timestamp ограничивает
+// окно, в течение которого кадр считается свежим, а HMAC — это
+// hex-encoded HMAC-SHA256 от "ID:Nonce:Timestamp", посчитанный общим
+// секретом (см. master.FrameAuthenticator).
+type AuthToken struct {
+	Nonce     string `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+	HMAC      string `json:"hmac"`
+}
+
+type RegisterPayload struct {
+	ID      string    `json:"id"`
+	Address string    `json:"address"`
+	Port    int       `json:"port"`
+	Auth    AuthToken `json:"auth,omitempty"`
+}
+
+type HeartbeatPayload struct {
+	ID   string    `json:"id"`
+	Auth AuthToken `json:"auth,omitempty"`
+}
+
+type LoadUpdatePayload struct {
+	ID   string `json:"id"`
+	Load int    `json:"load"`
+}
+
+type AckPayload struct {
+	Status   string       `json:"status"`
+	Encoding EncodingName `json:"encoding,omitempty"`
+}
+
+type ErrorPayload struct {
+	Message string `json:"message"`
+}
@@ -0,0 +1,278 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// protobufEncoding реализует стандартный бинарный protobuf wire-формат
+// (tag = field_number<<3|wire_type, затем varint или length-delimited
+// значение — см. https://protobuf.dev/programming-guides/encoding/) для
+// закрытого набора payload'ов пакета wire. Схема не генерируется из
+// .proto-файлов — их в репозитории нет — а прописана вручную в Marshal/
+// Unmarshal ниже; номера полей соответствуют порядку полей в messages.go
+// и должны меняться синхронно с ним. Поля со значением по умолчанию (""
+// /0/false) опускаются при записи, как того требует proto3.
+type protobufEncoding struct{}
+
+func (protobufEncoding) Name() EncodingName { return EncodingProtobuf }
+
+func (protobufEncoding) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	switch p := v.(type) {
+	case HandshakePayload:
+		writeString(&buf, 1, string(p.Encoding))
+		writeBool(&buf, 2, p.TLS)
+	case *HandshakePayload:
+		return protobufEncoding{}.Marshal(*p)
+
+	case AuthToken:
+		buf.Write(marshalAuthToken(p))
+	case *AuthToken:
+		return protobufEncoding{}.Marshal(*p)
+
+	case RegisterPayload:
+		writeString(&buf, 1, p.ID)
+		writeString(&buf, 2, p.Address)
+		writeVarint(&buf, 3, uint64(p.Port))
+		writeMessage(&buf, 4, marshalAuthToken(p.Auth))
+	case *RegisterPayload:
+		return protobufEncoding{}.Marshal(*p)
+
+	case HeartbeatPayload:
+		writeString(&buf, 1, p.ID)
+		writeMessage(&buf, 2, marshalAuthToken(p.Auth))
+	case *HeartbeatPayload:
+		return protobufEncoding{}.Marshal(*p)
+
+	case LoadUpdatePayload:
+		writeString(&buf, 1, p.ID)
+		writeVarint(&buf, 2, uint64(p.Load))
+	case *LoadUpdatePayload:
+		return protobufEncoding{}.Marshal(*p)
+
+	case AckPayload:
+		writeString(&buf, 1, p.Status)
+		writeString(&buf, 2, string(p.Encoding))
+	case *AckPayload:
+		return protobufEncoding{}.Marshal(*p)
+
+	case ErrorPayload:
+		writeString(&buf, 1, p.Message)
+	case *ErrorPayload:
+		return protobufEncoding{}.Marshal(*p)
+
+	default:
+		return nil, fmt.Errorf("wire: protobuf-кодирование не поддерживает тип %T", v)
+	}
+	return buf.Bytes(), nil
+}
+
+func (protobufEncoding) Unmarshal(data []byte, v interface{}) error {
+	switch p := v.(type) {
+	case *HandshakePayload:
+		return readProtobufFields(data, func(field int, value []byte, n uint64) error {
+			switch field {
+			case 1:
+				p.Encoding = EncodingName(value)
+			case 2:
+				p.TLS = n != 0
+			}
+			return nil
+		})
+
+	case *AuthToken:
+		return readProtobufFields(data, func(field int, value []byte, n uint64) error {
+			switch field {
+			case 1:
+				p.Nonce = string(value)
+			case 2:
+				p.Timestamp = int64(n)
+			case 3:
+				p.HMAC = string(value)
+			}
+			return nil
+		})
+
+	case *RegisterPayload:
+		return readProtobufFields(data, func(field int, value []byte, n uint64) error {
+			switch field {
+			case 1:
+				p.ID = string(value)
+			case 2:
+				p.Address = string(value)
+			case 3:
+				p.Port = int(n)
+			case 4:
+				return protobufEncoding{}.Unmarshal(value, &p.Auth)
+			}
+			return nil
+		})
+
+	case *HeartbeatPayload:
+		return readProtobufFields(data, func(field int, value []byte, n uint64) error {
+			switch field {
+			case 1:
+				p.ID = string(value)
+			case 2:
+				return protobufEncoding{}.Unmarshal(value, &p.Auth)
+			}
+			return nil
+		})
+
+	case *LoadUpdatePayload:
+		return readProtobufFields(data, func(field int, value []byte, n uint64) error {
+			switch field {
+			case 1:
+				p.ID = string(value)
+			case 2:
+				p.Load = int(n)
+			}
+			return nil
+		})
+
+	case *AckPayload:
+		return readProtobufFields(data, func(field int, value []byte, n uint64) error {
+			switch field {
+			case 1:
+				p.Status = string(value)
+			case 2:
+				p.Encoding = EncodingName(value)
+			}
+			return nil
+		})
+
+	case *ErrorPayload:
+		return readProtobufFields(data, func(field int, value []byte, n uint64) error {
+			if field == 1 {
+				p.Message = string(value)
+			}
+			return nil
+		})
+
+	default:
+		return fmt.Errorf("wire: protobuf-кодирование не поддерживает тип %T", v)
+	}
+}
+
+func marshalAuthToken(t AuthToken) []byte {
+	var buf bytes.Buffer
+	writeString(&buf, 1, t.Nonce)
+	writeVarint(&buf, 2, uint64(t.Timestamp))
+	writeString(&buf, 3, t.HMAC)
+	return buf.Bytes()
+}
+
+const (
+	protobufWireVarint = 0
+	protobufWireBytes  = 2
+)
+
+func writeTag(buf *bytes.Buffer, field int, wireType byte) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(field)<<3|uint64(wireType))
+	buf.Write(tmp[:n])
+}
+
+// writeVarint пишет поле с wire-типом varint, опуская его целиком при
+// нулевом значении — proto3 не различает "отсутствует" и "0".
+func writeVarint(buf *bytes.Buffer, field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	writeTag(buf, field, protobufWireVarint)
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func writeBool(buf *bytes.Buffer, field int, v bool) {
+	if !v {
+		return
+	}
+	writeTag(buf, field, protobufWireVarint)
+	buf.WriteByte(1)
+}
+
+func writeString(buf *bytes.Buffer, field int, s string) {
+	if s == "" {
+		return
+	}
+	writeLengthDelimited(buf, field, []byte(s))
+}
+
+// writeMessage пишет вложенное сообщение (например, AuthToken внутри
+// RegisterPayload) как length-delimited поле, опуская его, если оно пусто
+// (вложенное сообщение было нулевым значением).
+func writeMessage(buf *bytes.Buffer, field int, msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+	writeLengthDelimited(buf, field, msg)
+}
+
+func writeLengthDelimited(buf *bytes.Buffer, field int, b []byte) {
+	writeTag(buf, field, protobufWireBytes)
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(len(b)))
+	buf.Write(tmp[:n])
+	buf.Write(b)
+}
+
+// readProtobufFields разбирает поток tag+value и для каждого поля вызывает
+// fn с номером поля и либо сырыми байтами (wire-тип bytes — строки и
+// вложенные сообщения), либо разобранным varint'ом (wire-тип varint —
+// числа и bool). Неизвестные номера полей и поля с wire-типами fixed64/
+// fixed32, которые мы не используем, пропускаются молча, как того требует
+// совместимость protobuf вперёд/назад; устаревшие группы дают ошибку.
+func readProtobufFields(data []byte, fn func(field int, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("wire: повреждён tag protobuf-сообщения")
+		}
+		data = data[n:]
+
+		field := int(tag >> 3)
+		wireType := byte(tag & 0x7)
+
+		switch wireType {
+		case protobufWireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("wire: повреждён varint в protobuf-сообщении")
+			}
+			data = data[n:]
+			if err := fn(field, nil, v); err != nil {
+				return err
+			}
+		case protobufWireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("wire: повреждена длина length-delimited поля")
+			}
+			data = data[n:]
+			if uint64(len(data)) < length {
+				return fmt.Errorf("wire: обрезанное protobuf-сообщение")
+			}
+			value := data[:length]
+			data = data[length:]
+			if err := fn(field, value, 0); err != nil {
+				return err
+			}
+		case 1, 5: // fixed64, fixed32
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return fmt.Errorf("wire: обрезанное protobuf-сообщение")
+			}
+			data = data[size:]
+		default:
+			return fmt.Errorf("wire: неподдерживаемый protobuf wire-тип %d", wireType)
+		}
+	}
+	return nil
+}
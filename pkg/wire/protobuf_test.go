@@ -0,0 +1,153 @@
+package wire
+
+import "testing"
+
+func TestProtobufEncodingRoundTrip(t *testing.T) {
+	enc := protobufEncoding{}
+
+	t.Run("HandshakePayload", func(t *testing.T) {
+		in := HandshakePayload{Encoding: EncodingProtobuf, TLS: true}
+		data, err := enc.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var out HandshakePayload
+		if err := enc.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if out != in {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	})
+
+	t.Run("RegisterPayload with auth", func(t *testing.T) {
+		in := RegisterPayload{
+			ID:      "node-1",
+			Address: "10.0.0.5",
+			Port:    9000,
+			Auth:    AuthToken{Nonce: "abc123", Timestamp: 1700000000, HMAC: "deadbeef"},
+		}
+		data, err := enc.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var out RegisterPayload
+		if err := enc.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if out != in {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	})
+
+	t.Run("RegisterPayload without auth", func(t *testing.T) {
+		in := RegisterPayload{ID: "node-2", Address: "10.0.0.6", Port: 9001}
+		data, err := enc.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var out RegisterPayload
+		if err := enc.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if out != in {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	})
+
+	t.Run("HeartbeatPayload", func(t *testing.T) {
+		in := HeartbeatPayload{ID: "node-1", Auth: AuthToken{Nonce: "n", Timestamp: 42, HMAC: "h"}}
+		data, err := enc.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var out HeartbeatPayload
+		if err := enc.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if out != in {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	})
+
+	t.Run("LoadUpdatePayload", func(t *testing.T) {
+		in := LoadUpdatePayload{ID: "node-1", Load: 57}
+		data, err := enc.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var out LoadUpdatePayload
+		if err := enc.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if out != in {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	})
+
+	t.Run("LoadUpdatePayload zero load", func(t *testing.T) {
+		in := LoadUpdatePayload{ID: "node-1", Load: 0}
+		data, err := enc.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var out LoadUpdatePayload
+		if err := enc.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if out != in {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	})
+
+	t.Run("AckPayload", func(t *testing.T) {
+		in := AckPayload{Status: "ok", Encoding: EncodingJSON}
+		data, err := enc.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var out AckPayload
+		if err := enc.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if out != in {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	})
+
+	t.Run("ErrorPayload", func(t *testing.T) {
+		in := ErrorPayload{Message: "boom"}
+		data, err := enc.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var out ErrorPayload
+		if err := enc.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if out != in {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	})
+}
+
+func TestProtobufEncodingUnsupportedType(t *testing.T) {
+	enc := protobufEncoding{}
+	if _, err := enc.Marshal(42); err == nil {
+		t.Error("Marshal(42) = nil error, want error for unsupported type")
+	}
+	var dst int
+	if err := enc.Unmarshal([]byte{}, &dst); err == nil {
+		t.Error("Unmarshal into *int = nil error, want error for unsupported type")
+	}
+}
+
+func TestEncodingByNameAcceptsProtobuf(t *testing.T) {
+	enc, err := EncodingByName(EncodingProtobuf)
+	if err != nil {
+		t.Fatalf("EncodingByName(protobuf): %v", err)
+	}
+	if enc.Name() != EncodingProtobuf {
+		t.Errorf("Name() = %q, want %q", enc.Name(), EncodingProtobuf)
+	}
+}